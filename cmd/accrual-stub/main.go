@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/accrual/accrualtest"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logger := logging.NewLogger()
+
+	addr := flag.String("a", "localhost:8081", "server address")
+	scenariosPath := flag.String("scenarios", "", "JSON file with scenarios by order number, \"*\" sets the default")
+	accrualValue := flag.String("accrual", "500", "accrual for orders without scenario")
+	delay := flag.Duration("delay", 0, "processing delay for orders without scenario")
+	flag.Parse()
+
+	if envAddr := os.Getenv("RUN_ADDRESS"); envAddr != "" {
+		*addr = envAddr
+	}
+
+	scenarios := map[string]accrualtest.Scenario{}
+	if *scenariosPath != "" {
+		data, err := os.ReadFile(*scenariosPath)
+		if err != nil {
+			logger.Logger.Fatalw("Failed to read scenarios", "err", err)
+		}
+		if err := json.Unmarshal(data, &scenarios); err != nil {
+			logger.Logger.Fatalw("Failed to parse scenarios", "err", err)
+		}
+		for order, scenario := range scenarios {
+			if !scenario.Kind.Valid() {
+				logger.Logger.Fatalw("Scenario without kind", "order", order)
+			}
+		}
+	}
+
+	def, ok := scenarios["*"]
+	if !ok {
+		defAccrual, err := models.ParseMoney(*accrualValue)
+		if err != nil {
+			logger.Logger.Fatalw("Invalid accrual value", "err", err)
+		}
+		def = accrualtest.Scenario{
+			Kind:    accrualtest.KindProcessed,
+			Accrual: defAccrual,
+			Delay:   accrualtest.Duration(*delay),
+		}
+	}
+	delete(scenarios, "*")
+
+	stub := accrualtest.NewStub(def)
+	for order, scenario := range scenarios {
+		stub.Set(order, scenario)
+	}
+
+	server := &http.Server{Addr: *addr, Handler: logger.WithLogging(stub)}
+
+	go func() {
+		logger.Logger.Infow("Starting accrual stub", "addr", *addr, "scenarios", len(scenarios))
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Logger.Fatalw("create server error: ", err)
+		}
+	}()
+	<-ctx.Done()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Logger.Errorw("Server shutdown error", err)
+	}
+}
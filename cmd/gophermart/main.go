@@ -7,11 +7,17 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/sinfirst/Ref-System/internal/accrual"
 	"github.com/sinfirst/Ref-System/internal/app"
 	"github.com/sinfirst/Ref-System/internal/config"
+	"github.com/sinfirst/Ref-System/internal/expiry"
+	"github.com/sinfirst/Ref-System/internal/loyalty"
+	"github.com/sinfirst/Ref-System/internal/middleware/idempotency"
 	"github.com/sinfirst/Ref-System/internal/middleware/logging"
-	"github.com/sinfirst/Ref-System/internal/models"
+	"github.com/sinfirst/Ref-System/internal/processor"
+	"github.com/sinfirst/Ref-System/internal/reconcile"
 	"github.com/sinfirst/Ref-System/internal/router"
+	"github.com/sinfirst/Ref-System/internal/scheduler"
 	"github.com/sinfirst/Ref-System/internal/storage"
 	"github.com/sinfirst/Ref-System/internal/storage/pg"
 	"github.com/sinfirst/Ref-System/internal/worker"
@@ -21,18 +27,75 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	pollCh := make(chan models.TypeForChannel, 6)
 	logger := logging.NewLogger()
 	conf := config.NewConfig()
-	stg := storage.NewStorage(conf, logger)
-	db := pg.NewPGDB(conf, logger)
-	app := app.NewApp(stg, conf, logger, pollCh)
-	router := router.NewRouter(app, logger)
-	worker := worker.NewPollWorker(ctx, conf.AccurualSystemAddress, db, pollCh)
+	if err := conf.Validate(); err != nil {
+		logger.Logger.Fatalw("Invalid config:", err)
+	}
 	err := pg.InitMigrations(conf, logger)
 	if err != nil {
 		logger.Logger.Fatalw("Failed init migrations:", err)
 	}
+	stg := storage.NewStorage(conf, logger)
+	db := pg.NewPGDB(conf, logger)
+	routes, err := config.LoadAccrualRoutes(conf.AccrualRoutesFile)
+	if err != nil {
+		logger.Logger.Fatalw("Failed to load accrual routes:", err)
+	}
+	accrualClient, err := accrual.NewProviders(conf.AccurualSystemAddress, routes, logger)
+	if err != nil {
+		logger.Logger.Fatalw("Failed to configure accrual providers:", err)
+	}
+	conf.LoyaltyTiers, err = config.LoadLoyaltyTiers(conf.LoyaltyTiersFile)
+	if err != nil {
+		logger.Logger.Fatalw("Failed to load loyalty tiers:", err)
+	}
+	processor := processor.NewProcessor(db, logger)
+	app := app.NewApp(stg, conf, logger, processor, accrualClient)
+	router := router.NewRouter(app, conf, logger, db)
+	var pollWorker *worker.Worker
+	if conf.AccrualPollEnabled() {
+		dispatcher := worker.NewDispatcher(db, logger, conf.ReplicaID, conf.PollWorkers)
+		pollWorker = worker.NewPollWorker(ctx, accrualClient, db, processor, logger, dispatcher, conf.PollWorkers)
+	}
+	reconciler := reconcile.NewReconciler(db, accrualClient, logger, conf.ReconcileSample)
+	jobs := scheduler.New(db, logger)
+	jobs.Start(ctx, scheduler.Job{
+		Name:     "accrual reconciliation",
+		LockKey:  reconcile.LockKey,
+		Interval: conf.ReconcileInterval,
+		Run:      reconciler.Run,
+	})
+	expiryInterval := conf.PointsExpiryInterval
+	if conf.PointsTTL() <= 0 {
+		expiryInterval = 0
+	}
+	expirer := expiry.NewExpirer(db, conf.PointsTTL(), logger)
+	jobs.Start(ctx, scheduler.Job{
+		Name:     "points expiry",
+		LockKey:  expiry.LockKey,
+		Interval: expiryInterval,
+		Run:      expirer.Run,
+	})
+	jobs.Start(ctx, scheduler.Job{
+		Name:     "withdrawal holds release",
+		LockKey:  expiry.HoldsLockKey,
+		Interval: conf.HoldReleaseInterval,
+		Run:      expirer.ReleaseHolds,
+	})
+	jobs.Start(ctx, scheduler.Job{
+		Name:     "idempotency keys purge",
+		LockKey:  idempotency.PurgeLockKey,
+		Interval: idempotency.PurgeInterval,
+		Run:      idempotency.Purge(db, conf.IdempotencyKeyTTL),
+	})
+	jobs.Start(ctx, scheduler.Job{
+		Name:       "loyalty tiers recalculation",
+		LockKey:    loyalty.LockKey,
+		Interval:   conf.TierRecalcInterval,
+		RunAtStart: true,
+		Run:        loyalty.NewRecalculator(db, logger, conf.LoyaltyTiers).Run,
+	})
 	server := &http.Server{Addr: conf.ServerAdress, Handler: router}
 
 	go func() {
@@ -45,6 +108,8 @@ func main() {
 	if err := server.Shutdown(context.Background()); err != nil {
 		logger.Logger.Errorw("Server shutdown error", err)
 	}
-	worker.StopWorker()
-	close(pollCh)
+	if pollWorker != nil {
+		pollWorker.StopWorker()
+	}
+	jobs.Wait()
 }
@@ -0,0 +1,202 @@
+package accrualtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+type Kind string
+
+const (
+	KindProcessed     Kind = "processed"
+	KindInvalid       Kind = "invalid"
+	KindNotRegistered Kind = "not_registered"
+	KindRateLimited   Kind = "rate_limited"
+	KindServerError   Kind = "server_error"
+	KindMalformed     Kind = "malformed"
+)
+
+func (k Kind) Valid() bool {
+	switch k {
+	case KindProcessed, KindInvalid, KindNotRegistered, KindRateLimited, KindServerError, KindMalformed:
+		return true
+	}
+	return false
+}
+
+func (k *Kind) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	if !Kind(s).Valid() {
+		return fmt.Errorf("unknown scenario kind %q", s)
+	}
+	*k = Kind(s)
+	return nil
+}
+
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type Scenario struct {
+	Kind       Kind         `json:"kind"`
+	Accrual    models.Money `json:"accrual,omitempty"`
+	Delay      Duration     `json:"delay,omitempty"`
+	Times      int          `json:"times,omitempty"`
+	RetryAfter int          `json:"retry_after,omitempty"`
+	Limit      int          `json:"limit,omitempty"`
+}
+
+type state struct {
+	scenario  Scenario
+	firstSeen time.Time
+	calls     int
+}
+
+type Stub struct {
+	mu      sync.Mutex
+	def     Scenario
+	orders  map[string]*state
+	handler http.Handler
+}
+
+func NewStub(def Scenario) *Stub {
+	s := &Stub{
+		def:    def,
+		orders: make(map[string]*state),
+	}
+
+	router := chi.NewRouter()
+	router.Get("/api/orders/{number}", s.getOrder)
+	router.Put("/scenarios/{number}", s.putScenario)
+	s.handler = router
+
+	return s
+}
+
+func NewServer(def Scenario) (*httptest.Server, *Stub) {
+	stub := NewStub(def)
+	return httptest.NewServer(stub), stub
+}
+
+func (s *Stub) Set(order string, scenario Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orders[order] = &state{scenario: scenario}
+}
+
+func (s *Stub) Calls(order string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.orders[order]; ok {
+		return st.calls
+	}
+	return 0
+}
+
+func (s *Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Stub) putScenario(w http.ResponseWriter, r *http.Request) {
+	var scenario Scenario
+
+	if err := json.NewDecoder(r.Body).Decode(&scenario); err != nil || !scenario.Kind.Valid() {
+		http.Error(w, "invalid scenario", http.StatusBadRequest)
+		return
+	}
+
+	s.Set(chi.URLParam(r, "number"), scenario)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Stub) getOrder(w http.ResponseWriter, r *http.Request) {
+	order := chi.URLParam(r, "number")
+
+	s.mu.Lock()
+	st, ok := s.orders[order]
+	if !ok {
+		st = &state{scenario: s.def}
+		s.orders[order] = st
+	}
+	now := time.Now()
+	if st.firstSeen.IsZero() {
+		st.firstSeen = now
+	}
+	st.calls++
+	scenario := st.scenario
+	failing := scenario.Times == 0 || st.calls <= scenario.Times
+	elapsed := now.Sub(st.firstSeen)
+	s.mu.Unlock()
+
+	switch {
+	case !scenario.Kind.Valid():
+		http.Error(w, fmt.Sprintf("unknown scenario kind %q", scenario.Kind), http.StatusInternalServerError)
+	case scenario.Kind == KindNotRegistered:
+		w.WriteHeader(http.StatusNoContent)
+	case scenario.Kind == KindRateLimited && failing:
+		limit := scenario.Limit
+		if limit == 0 {
+			limit = 60
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(scenario.RetryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, "No more than %d requests per minute allowed", limit)
+	case scenario.Kind == KindServerError && failing:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	case scenario.Kind == KindMalformed && failing:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order": "` + order + `", "status": `))
+	case scenario.Kind == KindInvalid:
+		writeOrder(w, models.OrderResponse{Order: order, Status: models.StatusInvalid})
+	default:
+		writeOrder(w, processing(order, scenario, elapsed))
+	}
+}
+
+func processing(order string, scenario Scenario, elapsed time.Duration) models.OrderResponse {
+	delay := time.Duration(scenario.Delay)
+	switch {
+	case elapsed < delay/2:
+		return models.OrderResponse{Order: order, Status: models.StatusRegistered}
+	case elapsed < delay:
+		return models.OrderResponse{Order: order, Status: models.StatusProcessing}
+	default:
+		return models.OrderResponse{Order: order, Status: models.StatusProcessed, Accrual: scenario.Accrual}
+	}
+}
+
+func writeOrder(w http.ResponseWriter, response models.OrderResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
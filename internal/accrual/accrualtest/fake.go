@@ -0,0 +1,62 @@
+package accrualtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sinfirst/Ref-System/internal/accrual"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+type FakeResult struct {
+	Response models.OrderResponse
+	Err      error
+}
+
+type Fake struct {
+	mu      sync.Mutex
+	results map[string][]FakeResult
+	calls   map[string]int
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		results: make(map[string][]FakeResult),
+		calls:   make(map[string]int),
+	}
+}
+
+func (f *Fake) Set(order string, results ...FakeResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.results[order] = results
+}
+
+func (f *Fake) Calls(order string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[order]
+}
+
+func (f *Fake) GetOrderAccrual(ctx context.Context, order string) (models.OrderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return models.OrderResponse{}, err
+	}
+
+	f.calls[order]++
+	results := f.results[order]
+	if len(results) == 0 {
+		return models.OrderResponse{}, accrual.ErrOrderNotRegistered
+	}
+
+	result := results[0]
+	if len(results) > 1 {
+		f.results[order] = results[1:]
+	}
+	return result.Response, result.Err
+}
@@ -0,0 +1,232 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const (
+	defaultRetryAfter = 60 * time.Second
+	requestTimeout    = 5 * time.Second
+	maxRetries        = 3
+	baseBackoff       = 200 * time.Millisecond
+	maxBackoff        = 5 * time.Second
+	breakerThreshold  = 5
+	breakerCooldown   = 30 * time.Second
+)
+
+var ErrOrderNotRegistered = errors.New("order is not registered in accrual system")
+
+var limitPattern = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Limit      int
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("accrual rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+type serverError struct {
+	statusCode int
+}
+
+func (e *serverError) Error() string {
+	return fmt.Sprintf("accrual server error: %d", e.statusCode)
+}
+
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("poll error: %v", e.err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+type Options struct {
+	Token     string
+	RateLimit int
+}
+
+type HTTPClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	limiter *Limiter
+	breaker *Breaker
+	stats   *Stats
+	logger  *logging.Logger
+}
+
+func NewHTTPClient(baseURL string, opts Options, logger *logging.Logger) *HTTPClient {
+	limiter := NewLimiter()
+	limiter.SetLimit(opts.RateLimit)
+
+	return &HTTPClient{
+		baseURL: baseURL,
+		token:   opts.Token,
+		client:  &http.Client{Timeout: requestTimeout},
+		limiter: limiter,
+		breaker: NewBreaker(breakerThreshold, breakerCooldown),
+		stats:   &Stats{},
+		logger:  logger,
+	}
+}
+
+func (c *HTTPClient) Stats() *Stats {
+	return c.stats
+}
+
+func (c *HTTPClient) GetOrderAccrual(ctx context.Context, order string) (models.OrderResponse, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, backoff(attempt)); err != nil {
+				return models.OrderResponse{}, err
+			}
+		}
+
+		response, err := c.do(ctx, order)
+		if err == nil || ctx.Err() != nil || !isRetryable(err) {
+			return response, err
+		}
+
+		lastErr = err
+		c.logger.Logger.Warnw("accrual request failed", "order", order, "attempt", attempt+1, "err", err)
+	}
+
+	return models.OrderResponse{}, lastErr
+}
+
+func (c *HTTPClient) do(ctx context.Context, order string) (models.OrderResponse, error) {
+	var response models.OrderResponse
+
+	url := fmt.Sprintf("%s/api/orders/%s", c.baseURL, order)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return response, fmt.Errorf("req create error: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	if err := c.limiter.Wait(ctx); err != nil {
+		return response, err
+	}
+
+	// Allow goes last: in half-open state it takes the probe, which only Success or Failure give back.
+	if err := c.breaker.Allow(); err != nil {
+		return response, err
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	c.stats.observe(time.Since(start), resp)
+	if err != nil {
+		c.breaker.Failure()
+		return response, &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.breaker.Failure()
+		return response, &serverError{statusCode: resp.StatusCode}
+	}
+	c.breaker.Success()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response, fmt.Errorf("read response error: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.Unmarshal(body, &response); err != nil {
+			return response, fmt.Errorf("ошибка парсинга: %w", err)
+		}
+		return response, nil
+	case http.StatusNoContent:
+		return response, ErrOrderNotRegistered
+	case http.StatusTooManyRequests:
+		rateErr := &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Limit:      parseLimit(string(body)),
+		}
+		c.limiter.Pause(rateErr.RetryAfter)
+		c.limiter.SetLimit(rateErr.Limit)
+		return response, rateErr
+	default:
+		return response, fmt.Errorf("unexpected accrual status code: %d", resp.StatusCode)
+	}
+}
+
+func isRetryable(err error) bool {
+	var srvErr *serverError
+	var trErr *transportError
+	return errors.As(err, &srvErr) || errors.As(err, &trErr)
+}
+
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
+}
+
+func parseLimit(body string) int {
+	match := limitPattern.FindStringSubmatch(body)
+	if match == nil {
+		return 0
+	}
+
+	limit, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return limit
+}
@@ -0,0 +1,87 @@
+package accrual_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/accrual"
+	"github.com/sinfirst/Ref-System/internal/accrual/accrualtest"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func newTestClient(t *testing.T) (*accrual.HTTPClient, *accrualtest.Stub) {
+	t.Helper()
+
+	server, stub := accrualtest.NewServer(accrualtest.Scenario{Kind: accrualtest.KindProcessed, Accrual: 72998})
+	t.Cleanup(server.Close)
+
+	return accrual.NewHTTPClient(server.URL, accrual.Options{}, logging.NewLogger()), stub
+}
+
+func TestGetOrderAccrualProcessed(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	response, err := client.GetOrderAccrual(context.Background(), "12345678903")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != models.StatusProcessed || response.Accrual != 72998 {
+		t.Errorf("response = %+v, want PROCESSED 729.98", response)
+	}
+}
+
+func TestGetOrderAccrualNotRegistered(t *testing.T) {
+	client, stub := newTestClient(t)
+	stub.Set("1", accrualtest.Scenario{Kind: accrualtest.KindNotRegistered})
+
+	_, err := client.GetOrderAccrual(context.Background(), "1")
+	if !errors.Is(err, accrual.ErrOrderNotRegistered) {
+		t.Errorf("err = %v, want ErrOrderNotRegistered", err)
+	}
+}
+
+func TestGetOrderAccrualRetriesServerErrors(t *testing.T) {
+	client, stub := newTestClient(t)
+	stub.Set("1", accrualtest.Scenario{Kind: accrualtest.KindServerError, Times: 2, Accrual: 500})
+
+	response, err := client.GetOrderAccrual(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Accrual != 500 {
+		t.Errorf("accrual = %s, want 5", response.Accrual)
+	}
+	if calls := stub.Calls("1"); calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestGetOrderAccrualRateLimited(t *testing.T) {
+	client, stub := newTestClient(t)
+	stub.Set("1", accrualtest.Scenario{Kind: accrualtest.KindRateLimited, RetryAfter: 7, Limit: 30})
+
+	_, err := client.GetOrderAccrual(context.Background(), "1")
+	var rateErr *accrual.RateLimitError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("err = %v, want RateLimitError", err)
+	}
+	if rateErr.RetryAfter != 7*time.Second || rateErr.Limit != 30 {
+		t.Errorf("rate limit = %+v, want 7s and 30 rpm", rateErr)
+	}
+	if calls := stub.Calls("1"); calls != 1 {
+		t.Errorf("calls = %d, rate limited requests must not be retried", calls)
+	}
+}
+
+func TestGetOrderAccrualUnknownScenarioKind(t *testing.T) {
+	client, stub := newTestClient(t)
+	stub.Set("1", accrualtest.Scenario{Kind: "procesed"})
+
+	_, err := client.GetOrderAccrual(context.Background(), "1")
+	if err == nil {
+		t.Fatal("unknown scenario kind must fail")
+	}
+}
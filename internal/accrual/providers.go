@@ -0,0 +1,92 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sinfirst/Ref-System/internal/config"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const defaultProvider = "default"
+
+type provider struct {
+	name    string
+	prefix  string
+	pattern *regexp.Regexp
+	client  *HTTPClient
+}
+
+func (p provider) matches(order string) bool {
+	if p.prefix != "" && !strings.HasPrefix(order, p.prefix) {
+		return false
+	}
+	if p.pattern != nil && !p.pattern.MatchString(order) {
+		return false
+	}
+	return true
+}
+
+type Providers struct {
+	routes   []provider
+	fallback provider
+}
+
+func NewProviders(defaultURL string, routes []config.AccrualRoute, logger *logging.Logger) (*Providers, error) {
+	providers := &Providers{
+		fallback: provider{name: defaultProvider, client: NewHTTPClient(defaultURL, Options{}, logger)},
+	}
+
+	for i, route := range routes {
+		if route.URL == "" {
+			return nil, fmt.Errorf("accrual route %q has no url", route.Name)
+		}
+		if route.Prefix == "" && route.Pattern == "" && i != len(routes)-1 {
+			return nil, fmt.Errorf("accrual route %q matches every order and must be the last route", route.Name)
+		}
+
+		p := provider{
+			name:   route.Name,
+			prefix: route.Prefix,
+			client: NewHTTPClient(route.URL, Options{Token: route.Token, RateLimit: route.RateLimit}, logger),
+		}
+		if route.Pattern != "" {
+			pattern, err := regexp.Compile(route.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("accrual route %q: %w", route.Name, err)
+			}
+			p.pattern = pattern
+		}
+		if p.name == "" {
+			p.name = route.URL
+		}
+
+		providers.routes = append(providers.routes, p)
+	}
+
+	return providers, nil
+}
+
+func (p *Providers) GetOrderAccrual(ctx context.Context, order string) (models.OrderResponse, error) {
+	return p.route(order).client.GetOrderAccrual(ctx, order)
+}
+
+func (p *Providers) Stats() []models.ProviderStats {
+	stats := make([]models.ProviderStats, 0, len(p.routes)+1)
+	for _, route := range p.routes {
+		stats = append(stats, route.client.Stats().Snapshot(route.name))
+	}
+	return append(stats, p.fallback.client.Stats().Snapshot(p.fallback.name))
+}
+
+func (p *Providers) route(order string) provider {
+	for _, route := range p.routes {
+		if route.matches(order) {
+			return route
+		}
+	}
+	return p.fallback
+}
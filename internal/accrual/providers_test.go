@@ -0,0 +1,25 @@
+package accrual_test
+
+import (
+	"testing"
+
+	"github.com/sinfirst/Ref-System/internal/accrual"
+	"github.com/sinfirst/Ref-System/internal/config"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+)
+
+func TestNewProvidersRejectsShadowingCatchAll(t *testing.T) {
+	routes := []config.AccrualRoute{
+		{Name: "all", URL: "http://all"},
+		{Name: "partner", Prefix: "42", URL: "http://partner"},
+	}
+
+	if _, err := accrual.NewProviders("http://default", routes, logging.NewLogger()); err == nil {
+		t.Fatal("catch-all route before other routes must be rejected")
+	}
+
+	routes[0], routes[1] = routes[1], routes[0]
+	if _, err := accrual.NewProviders("http://default", routes, logging.NewLogger()); err != nil {
+		t.Fatalf("catch-all as the last route: %v", err)
+	}
+}
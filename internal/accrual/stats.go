@@ -0,0 +1,52 @@
+package accrual
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+type Stats struct {
+	mu           sync.Mutex
+	requests     int64
+	failures     int64
+	rateLimited  int64
+	serverErrors int64
+	lastLatency  time.Duration
+	lastRequest  time.Time
+}
+
+func (s *Stats) observe(latency time.Duration, resp *http.Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	s.lastLatency = latency
+	s.lastRequest = time.Now()
+
+	switch {
+	case resp == nil:
+		s.failures++
+	case resp.StatusCode == http.StatusTooManyRequests:
+		s.rateLimited++
+	case resp.StatusCode >= http.StatusInternalServerError:
+		s.serverErrors++
+	}
+}
+
+func (s *Stats) Snapshot(name string) models.ProviderStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return models.ProviderStats{
+		Name:         name,
+		Requests:     s.requests,
+		Failures:     s.failures,
+		RateLimited:  s.rateLimited,
+		ServerErrors: s.serverErrors,
+		LastLatency:  s.lastLatency.String(),
+		LastRequest:  s.lastRequest,
+	}
+}
@@ -3,9 +3,11 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/sinfirst/Ref-System/internal/config"
 	"github.com/sinfirst/Ref-System/internal/functions"
@@ -22,22 +24,42 @@ type Storage interface {
 	GetOrderAndUser(ctx context.Context, order string) (string, string, error)
 	AddOrderToDB(ctx context.Context, order string, username string) error
 	UpdateStatus(ctx context.Context, newStatus, order, user string) error
-	UpdateUserBalance(ctx context.Context, user string, accrual, withdrawn float64) error
 	GetUserOrders(ctx context.Context, user string) ([]models.Order, error)
 	GetUserBalance(ctx context.Context, user string) (models.UserBalance, error)
-	SetUserWithdrawn(ctx context.Context, orderNum, user string, withdrawn float64) error
+	ExpiringPoints(ctx context.Context, user string, ttl, window time.Duration) (models.Money, error)
+	Withdraw(ctx context.Context, user, orderNum, merchant string, sum models.Money) error
 	GetUserWithdrawns(ctx context.Context, user string) ([]models.UserWithdrawal, error)
+	HoldWithdrawal(ctx context.Context, user, orderNum, merchant string, sum models.Money, ttl time.Duration) (models.WithdrawalHold, error)
+	CaptureHold(ctx context.Context, user, merchant, orderNum string) (models.WithdrawalHold, error)
+	ReleaseHold(ctx context.Context, user, merchant, orderNum string) (models.WithdrawalHold, error)
+	Transfer(ctx context.Context, from, to string, sum, maxAmount, dailyLimit models.Money) error
+	ReverseWithdrawal(ctx context.Context, orderNum, merchant string, amount models.Money, reason, actor string) (models.WithdrawalReversal, error)
+	GetDeadLetters(ctx context.Context, after *models.DeadLetterCursor, limit int) (models.DeadLetterPage, error)
+	RequeueDeadLetter(ctx context.Context, order string) error
+	GetAccrualDiscrepancies(ctx context.Context) ([]models.AccrualDiscrepancy, error)
+	CheckLedger(ctx context.Context) (models.LedgerReport, error)
+	GetUserProfile(ctx context.Context, user string) (models.UserProfile, error)
+	GetBalanceHistory(ctx context.Context, user string, filter models.BalanceHistoryFilter) (models.BalanceHistory, error)
+}
+
+type AccrualApplier interface {
+	Apply(ctx context.Context, order, user string, response models.OrderResponse) (bool, error)
+}
+
+type ProviderStats interface {
+	Stats() []models.ProviderStats
 }
 
 type App struct {
-	storage Storage
-	config  config.Config
-	logger  *logging.Logger
-	pollCh  chan models.TypeForChannel
+	storage   Storage
+	config    config.Config
+	logger    *logging.Logger
+	accrual   AccrualApplier
+	providers ProviderStats
 }
 
-func NewApp(storage Storage, config config.Config, logger *logging.Logger, pollCh chan models.TypeForChannel) *App {
-	return &App{storage: storage, config: config, logger: logger, pollCh: pollCh}
+func NewApp(storage Storage, config config.Config, logger *logging.Logger, accrual AccrualApplier, providers ProviderStats) *App {
+	return &App{storage: storage, config: config, logger: logger, accrual: accrual, providers: providers}
 }
 
 func (a *App) Register(w http.ResponseWriter, r *http.Request) {
@@ -151,7 +173,7 @@ func (a *App) OrdersIn(w http.ResponseWriter, r *http.Request) {
 	order, username, err := a.storage.GetOrderAndUser(r.Context(), string(body))
 	if err == nil && order == string(body) {
 		if user == username {
-			http.Error(w, "order already exist", http.StatusOK)
+			w.WriteHeader(http.StatusOK)
 			return
 		} else {
 			http.Error(w, "order upload another user", http.StatusConflict)
@@ -166,11 +188,9 @@ func (a *App) OrdersIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	a.pollCh <- models.TypeForChannel{User: user, OrderNum: string(body)}
 	w.WriteHeader(http.StatusAccepted)
 }
 func (a *App) OrdersInfo(w http.ResponseWriter, r *http.Request) {
-	var ordersFloat []models.OrderFloat
 	ctxK := models.CtxKey("userName")
 	value := r.Context().Value(ctxK)
 	user := fmt.Sprintf("%v", value)
@@ -182,29 +202,13 @@ func (a *App) OrdersInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userBalance, err := a.storage.GetUserBalance(r.Context(), user)
-	if err != nil {
-		a.logger.Logger.Errorf("err: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	for _, order := range orders {
-		ordersFloat = append(ordersFloat, models.OrderFloat{
-			Number:   order.Number,
-			Status:   order.Status,
-			Accrual:  float64(userBalance.Current),
-			UploadAt: order.UploadAt,
-		})
-	}
-
-	if len(ordersFloat) == 0 {
+	if len(orders) == 0 {
 		http.Error(w, "order list is empty", http.StatusNoContent)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(ordersFloat)
+	err = json.NewEncoder(w).Encode(orders)
 	if err != nil {
 		a.logger.Logger.Errorf("err: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -223,6 +227,13 @@ func (a *App) GetBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	balance.ExpiringSoon, err = a.storage.ExpiringPoints(r.Context(), user, a.config.PointsTTL(), a.config.PointsExpiringWindow())
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(balance)
 	if err != nil {
@@ -232,7 +243,7 @@ func (a *App) GetBalance(w http.ResponseWriter, r *http.Request) {
 	}
 }
 func (a *App) Withdraw(w http.ResponseWriter, r *http.Request) {
-	var data models.UserWithdrawal
+	var data models.WithdrawRequest
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "invalid request ", http.StatusUnprocessableEntity)
@@ -252,28 +263,30 @@ func (a *App) Withdraw(w http.ResponseWriter, r *http.Request) {
 	value := r.Context().Value(ctxK)
 	user := fmt.Sprintf("%v", value)
 
-	userBalance, err := a.storage.GetUserBalance(r.Context(), user)
-
-	if err != nil {
-		a.logger.Logger.Errorf("err: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if data.Sum <= 0 {
+		http.Error(w, "invalid sum", http.StatusUnprocessableEntity)
 		return
 	}
 
-	if userBalance.Current < data.Sum {
-		http.Error(w, "Not enough balance", http.StatusPaymentRequired)
+	if _, ok := a.config.MerchantTokens[data.Merchant]; data.Merchant != "" && !ok {
+		http.Error(w, "unknown merchant", http.StatusUnprocessableEntity)
 		return
 	}
 
-	finalSum := userBalance.Current - data.Sum
-	err = a.storage.UpdateUserBalance(r.Context(), user, finalSum, data.Sum)
-	if err != nil {
-		a.logger.Logger.Errorf("err: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if data.Hold {
+		a.holdWithdrawal(w, r, user, data)
 		return
 	}
 
-	err = a.storage.SetUserWithdrawn(r.Context(), data.OrderNum, user, data.Sum)
+	err = a.storage.Withdraw(r.Context(), user, data.OrderNum, data.Merchant, data.Sum)
+	if errors.Is(err, models.ErrInsufficientFunds) {
+		http.Error(w, "Not enough balance", http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, models.ErrWithdrawalExists) {
+		http.Error(w, "order already withdrawn", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		a.logger.Logger.Errorf("err: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func (a *App) AccrualCallback(w http.ResponseWriter, r *http.Request) {
+	var response models.OrderResponse
+
+	if err := json.NewDecoder(r.Body).Decode(&response); err != nil || response.Order == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	switch response.Status {
+	case models.StatusRegistered, models.StatusProcessing, models.StatusInvalid, models.StatusProcessed:
+	default:
+		http.Error(w, "unknown order status", http.StatusBadRequest)
+		return
+	}
+	if response.Accrual < 0 {
+		http.Error(w, "negative accrual", http.StatusBadRequest)
+		return
+	}
+
+	_, user, err := a.storage.GetOrderAndUser(r.Context(), response.Order)
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = a.accrual.Apply(r.Context(), response.Order, user, response)
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+func (a *App) BalanceHistory(w http.ResponseWriter, r *http.Request) {
+	ctxK := models.CtxKey("userName")
+	value := r.Context().Value(ctxK)
+	user := fmt.Sprintf("%v", value)
+
+	filter, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := a.storage.GetBalanceHistory(r.Context(), user, filter)
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(history.Items) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(history)
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseHistoryFilter(query url.Values) (models.BalanceHistoryFilter, error) {
+	filter := models.BalanceHistoryFilter{}
+
+	limit, err := parseLimit(query.Get("limit"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit = limit
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || cursor < 0 {
+			return filter, fmt.Errorf("invalid cursor")
+		}
+		filter.Cursor = cursor
+	}
+
+	switch v := query.Get("type"); v {
+	case "", models.EntryCredit, models.EntryDebit:
+		filter.Type = v
+	default:
+		return filter, fmt.Errorf("invalid type, expected credit or debit")
+	}
+
+	if filter.From, err = parseHistoryTime(query.Get("from"), false); err != nil {
+		return filter, fmt.Errorf("invalid from")
+	}
+	if filter.To, err = parseHistoryTime(query.Get("to"), true); err != nil {
+		return filter, fmt.Errorf("invalid to")
+	}
+
+	return filter, nil
+}
+
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return defaultPageLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	return min(limit, maxPageLimit), nil
+}
+
+// A bare date in "to" covers the whole day, so the bound moves to the next midnight.
+func parseHistoryTime(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func (a *App) holdWithdrawal(w http.ResponseWriter, r *http.Request, user string, data models.WithdrawRequest) {
+	hold, err := a.storage.HoldWithdrawal(r.Context(), user, data.OrderNum, data.Merchant, data.Sum, a.config.HoldTTL)
+	if errors.Is(err, models.ErrInsufficientFunds) {
+		http.Error(w, "Not enough balance", http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, models.ErrWithdrawalExists) {
+		http.Error(w, "order already withdrawn", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.writeHold(w, http.StatusAccepted, hold)
+}
+
+func (a *App) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	user := fmt.Sprintf("%v", r.Context().Value(models.CtxKey("userName")))
+	a.captureHold(w, r, user, "")
+}
+
+func (a *App) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	user := fmt.Sprintf("%v", r.Context().Value(models.CtxKey("userName")))
+	a.releaseHold(w, r, user, "")
+}
+
+func (a *App) MerchantCaptureHold(w http.ResponseWriter, r *http.Request) {
+	merchant := fmt.Sprintf("%v", r.Context().Value(models.CtxKey("merchant")))
+	a.captureHold(w, r, "", merchant)
+}
+
+func (a *App) MerchantReleaseHold(w http.ResponseWriter, r *http.Request) {
+	merchant := fmt.Sprintf("%v", r.Context().Value(models.CtxKey("merchant")))
+	a.releaseHold(w, r, "", merchant)
+}
+
+func (a *App) captureHold(w http.ResponseWriter, r *http.Request, user, merchant string) {
+	hold, err := a.storage.CaptureHold(r.Context(), user, merchant, chi.URLParam(r, "order"))
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "active hold not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrHoldExpired) {
+		http.Error(w, "hold expired and was released", http.StatusGone)
+		return
+	}
+	if errors.Is(err, models.ErrInsufficientFunds) {
+		http.Error(w, "Not enough balance", http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, models.ErrWithdrawalExists) {
+		http.Error(w, "order already withdrawn", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.writeHold(w, http.StatusOK, hold)
+}
+
+func (a *App) releaseHold(w http.ResponseWriter, r *http.Request, user, merchant string) {
+	hold, err := a.storage.ReleaseHold(r.Context(), user, merchant, chi.URLParam(r, "order"))
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "active hold not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.writeHold(w, http.StatusOK, hold)
+}
+
+func (a *App) writeHold(w http.ResponseWriter, status int, hold models.WithdrawalHold) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(hold); err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func (a *App) Profile(w http.ResponseWriter, r *http.Request) {
+	ctxK := models.CtxKey("userName")
+	value := r.Context().Value(ctxK)
+	user := fmt.Sprintf("%v", value)
+
+	profile, err := a.storage.GetUserProfile(r.Context(), user)
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if next, ok := a.config.NextLoyaltyTier(profile.LifetimeAccrual); ok {
+		profile.NextTier = next.Name
+		profile.NextTierAt = next.MinAccrual
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(profile)
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func (a *App) AdminReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	a.reverseWithdrawal(w, r, "", "admin")
+}
+
+func (a *App) MerchantReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	merchant := fmt.Sprintf("%v", r.Context().Value(models.CtxKey("merchant")))
+	a.reverseWithdrawal(w, r, merchant, "merchant:"+merchant)
+}
+
+func (a *App) reverseWithdrawal(w http.ResponseWriter, r *http.Request, merchant, actor string) {
+	var req models.ReversalRequest
+	order := chi.URLParam(r, "order")
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusUnprocessableEntity)
+		return
+	}
+	if req.Amount < 0 {
+		http.Error(w, "invalid amount", http.StatusUnprocessableEntity)
+		return
+	}
+
+	reversal, err := a.storage.ReverseWithdrawal(r.Context(), order, merchant, req.Amount, req.Reason, actor)
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "withdrawal not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrReversalExceeds) {
+		http.Error(w, "amount exceeds the withdrawn sum left to reverse", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	a.logger.Logger.Infow("withdrawal reversed", "order", order, "amount", reversal.Amount.String(), "actor", actor)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(reversal)
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
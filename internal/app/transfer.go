@@ -0,0 +1,51 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func (a *App) Transfer(w http.ResponseWriter, r *http.Request) {
+	var req models.TransferRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	ctxK := models.CtxKey("userName")
+	value := r.Context().Value(ctxK)
+	user := fmt.Sprintf("%v", value)
+
+	if req.To == "" || req.To == user {
+		http.Error(w, "invalid recipient", http.StatusUnprocessableEntity)
+		return
+	}
+	if req.Sum <= 0 {
+		http.Error(w, "invalid sum", http.StatusUnprocessableEntity)
+		return
+	}
+
+	err := a.storage.Transfer(r.Context(), user, req.To, req.Sum, a.config.TransferMaxAmount, a.config.TransferDailyLimit)
+	if errors.Is(err, models.ErrInsufficientFunds) {
+		http.Error(w, "Not enough balance", http.StatusPaymentRequired)
+		return
+	}
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "recipient not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrTransferLimit) {
+		http.Error(w, "transfer limit exceeded", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		a.logger.Logger.Errorf("err: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
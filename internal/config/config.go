@@ -1,20 +1,59 @@
 package config
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/sinfirst/Ref-System/internal/models"
 )
 
 var TokenExp = time.Hour * 12
 var SecretKey = "supersecretkey"
 
 type Config struct {
-	ServerAdress          string `env:"RUN_ADDRESS"`
-	DatabaseDsn           string `env:"DATABASE_URI"`
-	AccurualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	ServerAdress          string            `env:"RUN_ADDRESS"`
+	DatabaseDsn           string            `env:"DATABASE_URI"`
+	AccurualSystemAddress string            `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	PollWorkers           int               `env:"ACCRUAL_POLL_WORKERS" envDefault:"4"`
+	AdminToken            string            `env:"ADMIN_TOKEN"`
+	ReplicaID             string            `env:"REPLICA_ID"`
+	ReconcileInterval     time.Duration     `env:"RECONCILE_INTERVAL" envDefault:"24h"`
+	ReconcileSample       int               `env:"RECONCILE_SAMPLE" envDefault:"100"`
+	AccrualMode           string            `env:"ACCRUAL_MODE" envDefault:"poll"`
+	AccrualCallbackSecret string            `env:"ACCRUAL_CALLBACK_SECRET"`
+	AccrualRoutesFile     string            `env:"ACCRUAL_ROUTES_FILE"`
+	MerchantTokens        map[string]string `env:"MERCHANT_TOKENS"`
+	PointsTTLDays         int               `env:"POINTS_TTL_DAYS" envDefault:"0"`
+	PointsExpiringDays    int               `env:"POINTS_EXPIRING_DAYS" envDefault:"7"`
+	PointsExpiryInterval  time.Duration     `env:"POINTS_EXPIRY_INTERVAL" envDefault:"1h"`
+	HoldTTL               time.Duration     `env:"WITHDRAWAL_HOLD_TTL" envDefault:"15m"`
+	HoldReleaseInterval   time.Duration     `env:"WITHDRAWAL_HOLD_RELEASE_INTERVAL" envDefault:"1m"`
+	IdempotencyKeyTTL     time.Duration     `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
+	TransferMaxAmount     models.Money      `env:"TRANSFER_MAX_AMOUNT" envDefault:"0"`
+	TransferDailyLimit    models.Money      `env:"TRANSFER_DAILY_LIMIT" envDefault:"0"`
+	LoyaltyTiersFile      string            `env:"LOYALTY_TIERS_FILE"`
+	TierRecalcInterval    time.Duration     `env:"TIER_RECALC_INTERVAL" envDefault:"24h"`
+	LoyaltyTiers          []LoyaltyTier
+}
+
+type AccrualRoute struct {
+	Name      string `json:"name"`
+	Prefix    string `json:"prefix,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	URL       string `json:"url"`
+	Token     string `json:"token,omitempty"`
+	RateLimit int    `json:"rate_limit,omitempty"`
+}
+
+type LoyaltyTier struct {
+	Name       string       `json:"name"`
+	MinAccrual models.Money `json:"min_accrual"`
+	Multiplier models.Money `json:"multiplier"`
 }
 
 func NewConfig() Config {
@@ -25,6 +64,11 @@ func NewConfig() Config {
 		fmt.Println(err)
 	}
 
+	if conf.ReplicaID == "" {
+		hostname, _ := os.Hostname()
+		conf.ReplicaID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
 	if conf.DatabaseDsn != "" && conf.ServerAdress != "" && conf.AccurualSystemAddress != "" {
 		fmt.Printf("env: %v", conf.DatabaseDsn)
 		return conf
@@ -39,8 +83,109 @@ func NewConfig() Config {
 	}
 
 	flag.StringVar(&conf.ServerAdress, "a", "localhost:8080", "server address")
+	flag.IntVar(&conf.PollWorkers, "w", conf.PollWorkers, "accrual poll workers count")
+	flag.StringVar(&conf.AdminToken, "admin-token", conf.AdminToken, "admin API bearer token")
+	flag.DurationVar(&conf.ReconcileInterval, "reconcile-interval", conf.ReconcileInterval, "accrual reconciliation interval, 0 disables")
+	flag.IntVar(&conf.ReconcileSample, "reconcile-sample", conf.ReconcileSample, "processed orders per reconciliation, 0 checks all")
+
+	flag.StringVar(&conf.AccrualMode, "accrual-mode", conf.AccrualMode, "accrual delivery mode: poll, push or both")
+	flag.StringVar(&conf.AccrualCallbackSecret, "accrual-callback-secret", conf.AccrualCallbackSecret, "accrual callback HMAC secret")
+	flag.StringVar(&conf.AccrualRoutesFile, "accrual-routes", conf.AccrualRoutesFile, "JSON file with accrual provider routes")
+
+	flag.IntVar(&conf.PointsTTLDays, "points-ttl-days", conf.PointsTTLDays, "days before accrued points expire, 0 disables")
+	flag.IntVar(&conf.PointsExpiringDays, "points-expiring-days", conf.PointsExpiringDays, "window reported as expiring soon, days")
+	flag.DurationVar(&conf.PointsExpiryInterval, "points-expiry-interval", conf.PointsExpiryInterval, "points expiry job interval")
+	flag.DurationVar(&conf.HoldTTL, "hold-ttl", conf.HoldTTL, "withdrawal hold lifetime before automatic release")
+	flag.DurationVar(&conf.HoldReleaseInterval, "hold-release-interval", conf.HoldReleaseInterval, "expired withdrawal holds release interval")
+	flag.DurationVar(&conf.IdempotencyKeyTTL, "idempotency-key-ttl", conf.IdempotencyKeyTTL, "how long Idempotency-Key responses are kept")
+	flag.TextVar(&conf.TransferMaxAmount, "transfer-max", conf.TransferMaxAmount, "max points per transfer, 0 is unlimited")
+	flag.TextVar(&conf.TransferDailyLimit, "transfer-daily-limit", conf.TransferDailyLimit, "max points sent per 24h, 0 is unlimited")
+	flag.StringVar(&conf.LoyaltyTiersFile, "loyalty-tiers", conf.LoyaltyTiersFile, "JSON file with loyalty tiers")
+	flag.DurationVar(&conf.TierRecalcInterval, "tier-recalc-interval", conf.TierRecalcInterval, "loyalty tiers recalculation interval, 0 disables")
 
 	flag.Parse()
 
 	return conf
 }
+
+func (c Config) Validate() error {
+	switch c.AccrualMode {
+	case "poll", "push", "both":
+	default:
+		return fmt.Errorf("invalid accrual mode %q, want poll, push or both", c.AccrualMode)
+	}
+	return nil
+}
+
+func (c Config) AccrualPollEnabled() bool {
+	return c.AccrualMode != "push"
+}
+
+func (c Config) AccrualPushEnabled() bool {
+	return c.AccrualMode == "push" || c.AccrualMode == "both"
+}
+
+func (c Config) PointsTTL() time.Duration {
+	return time.Duration(c.PointsTTLDays) * 24 * time.Hour
+}
+
+func (c Config) PointsExpiringWindow() time.Duration {
+	return time.Duration(c.PointsExpiringDays) * 24 * time.Hour
+}
+
+func LoadAccrualRoutes(path string) ([]AccrualRoute, error) {
+	var routes []AccrualRoute
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parse accrual routes: %w", err)
+	}
+
+	return routes, nil
+}
+
+func LoadLoyaltyTiers(path string) ([]LoyaltyTier, error) {
+	var tiers []LoyaltyTier
+
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &tiers); err != nil {
+		return nil, fmt.Errorf("parse loyalty tiers: %w", err)
+	}
+
+	for _, tier := range tiers {
+		if tier.Name == "" || tier.MinAccrual < 0 {
+			return nil, fmt.Errorf("invalid loyalty tier %q", tier.Name)
+		}
+		if tier.Multiplier < 100 {
+			return nil, fmt.Errorf("loyalty tier %q multiplier %s is below 1", tier.Name, tier.Multiplier)
+		}
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinAccrual < tiers[j].MinAccrual })
+
+	return tiers, nil
+}
+
+func (c Config) NextLoyaltyTier(lifetime models.Money) (LoyaltyTier, bool) {
+	for _, tier := range c.LoyaltyTiers {
+		if tier.MinAccrual > lifetime {
+			return tier, true
+		}
+	}
+	return LoyaltyTier{}, false
+}
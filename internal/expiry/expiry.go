@@ -0,0 +1,46 @@
+package expiry
+
+import (
+	"context"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/storage/pg"
+)
+
+const (
+	LockKey      int64 = 4203
+	HoldsLockKey int64 = 4204
+)
+
+type Expirer struct {
+	db     *pg.PGDB
+	ttl    time.Duration
+	logger *logging.Logger
+}
+
+func NewExpirer(db *pg.PGDB, ttl time.Duration, logger *logging.Logger) *Expirer {
+	return &Expirer{db: db, ttl: ttl, logger: logger}
+}
+
+func (e *Expirer) Run(ctx context.Context) error {
+	expired, err := e.db.ExpirePoints(ctx, e.ttl)
+	if err != nil {
+		return err
+	}
+
+	e.logger.Logger.Infow("Points expiry finished", "expired", expired.String())
+	return nil
+}
+
+func (e *Expirer) ReleaseHolds(ctx context.Context) error {
+	released, err := e.db.ReleaseExpiredHolds(ctx)
+	if err != nil {
+		return err
+	}
+
+	if released > 0 {
+		e.logger.Logger.Infow("Expired withdrawal holds released", "count", released)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package loyalty
+
+import (
+	"context"
+
+	"github.com/sinfirst/Ref-System/internal/config"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/storage/pg"
+)
+
+const LockKey int64 = 4206
+
+type Recalculator struct {
+	db     *pg.PGDB
+	logger *logging.Logger
+	tiers  []config.LoyaltyTier
+}
+
+func NewRecalculator(db *pg.PGDB, logger *logging.Logger, tiers []config.LoyaltyTier) *Recalculator {
+	return &Recalculator{db: db, logger: logger, tiers: tiers}
+}
+
+func (r *Recalculator) Run(ctx context.Context) error {
+	changes, err := r.db.RecalculateTiers(ctx, r.tiers)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		r.logger.Logger.Infow("Loyalty tier changed", "user", c.User, "from", c.From, "to", c.To)
+	}
+	r.logger.Logger.Infow("Loyalty tiers recalculation finished", "changed", len(changes))
+	return nil
+}
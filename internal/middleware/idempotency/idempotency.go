@@ -0,0 +1,144 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const PurgeLockKey int64 = 4205
+
+const (
+	KeyHeader      = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+	PurgeInterval  = time.Hour
+	maxKeyLength   = 255
+	staleAfter     = time.Minute
+	retryAfter     = "1"
+)
+
+type Store interface {
+	BeginIdempotencyKey(ctx context.Context, user, key, hash string, staleBefore time.Time) (models.IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, user, key string, lockedAt time.Time, status int, contentType string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, user, key string, lockedAt time.Time) error
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Handle must run after auth, keys are scoped per user. Its own answers use codes the wrapped
+// endpoints don't: 425 with Retry-After while the first request with the key is in progress,
+// 400 when the key is reused with a different request.
+func Handle(store Store, logger *logging.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(KeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			user := fmt.Sprintf("%v", r.Context().Value(models.CtxKey("userName")))
+			hash := requestHash(r, body)
+
+			record, acquired, err := store.BeginIdempotencyKey(r.Context(), user, key, hash, time.Now().Add(-staleAfter))
+			if err != nil {
+				logger.Logger.Errorf("err: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if !acquired {
+				switch {
+				case record.RequestHash != hash:
+					http.Error(w, "Idempotency-Key was used with a different request", http.StatusBadRequest)
+				case record.StatusCode == 0:
+					w.Header().Set("Retry-After", retryAfter)
+					http.Error(w, "request with this Idempotency-Key is in progress", http.StatusTooEarly)
+				default:
+					if record.ContentType != "" {
+						w.Header().Set("Content-Type", record.ContentType)
+					}
+					w.Header().Set(ReplayedHeader, "true")
+					w.WriteHeader(record.StatusCode)
+					w.Write(record.Body)
+				}
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status >= http.StatusInternalServerError {
+				err = store.ReleaseIdempotencyKey(ctx, user, key, record.LockedAt)
+			} else {
+				err = store.CompleteIdempotencyKey(ctx, user, key, record.LockedAt, rec.status, w.Header().Get("Content-Type"),
+					rec.body.Bytes())
+			}
+			if errors.Is(err, models.ErrIdempotencyLost) {
+				logger.Logger.Warnw("Idempotency key was taken over as stale, response not stored", "key", key)
+				return
+			}
+			if err != nil {
+				logger.Logger.Errorw("Failed to store idempotent response", "key", key, "err", err)
+			}
+		})
+	}
+}
+
+func Purge(store Store, ttl time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := store.PurgeIdempotencyKeys(ctx, time.Now().Add(-ttl))
+		return err
+	}
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
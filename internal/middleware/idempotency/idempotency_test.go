@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+type memStore struct {
+	records map[string]models.IdempotencyRecord
+}
+
+func (s *memStore) BeginIdempotencyKey(ctx context.Context, user, key, hash string, staleBefore time.Time) (models.IdempotencyRecord, bool, error) {
+	if record, ok := s.records[user+key]; ok {
+		return record, false, nil
+	}
+	record := models.IdempotencyRecord{RequestHash: hash, LockedAt: time.Now()}
+	s.records[user+key] = record
+	return record, true, nil
+}
+
+func (s *memStore) CompleteIdempotencyKey(ctx context.Context, user, key string, lockedAt time.Time, status int, contentType string, body []byte) error {
+	record := s.records[user+key]
+	record.StatusCode, record.ContentType, record.Body = status, contentType, body
+	s.records[user+key] = record
+	return nil
+}
+
+func (s *memStore) ReleaseIdempotencyKey(ctx context.Context, user, key string, lockedAt time.Time) error {
+	delete(s.records, user+key)
+	return nil
+}
+
+func (s *memStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func newRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+	r.Header.Set(KeyHeader, "k1")
+	return r.WithContext(context.WithValue(r.Context(), models.CtxKey("userName"), "alice"))
+}
+
+func send(handler http.Handler, body string) *httptest.ResponseRecorder {
+	r := newRequest(body)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestHandleStatusCodes(t *testing.T) {
+	store := &memStore{records: make(map[string]models.IdempotencyRecord)}
+	calls := 0
+	handler := Handle(store, logging.NewLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// The first request is still running while the key is locked without a stored response.
+	store.records["alicek1"] = models.IdempotencyRecord{RequestHash: requestHash(newRequest(""), []byte(`{"sum":1}`))}
+	rec := send(handler, `{"sum":1}`)
+	if rec.Code != http.StatusTooEarly || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("in progress: status = %d, Retry-After = %q, want 425 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	delete(store.records, "alicek1")
+	if rec := send(handler, `{"sum":1}`); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d", rec.Code)
+	}
+	if rec := send(handler, `{"sum":1}`); rec.Code != http.StatusOK || rec.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("replay: status = %d, replayed = %q", rec.Code, rec.Header().Get(ReplayedHeader))
+	}
+	if rec := send(handler, `{"sum":2}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("different request: status = %d, want 400", rec.Code)
+	}
+	if calls != 1 {
+		t.Errorf("handler calls = %d, want 1", calls)
+	}
+}
@@ -0,0 +1,77 @@
+package signature
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "secret"
+
+func signedRequest(body, timestamp, sig string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/accrual/callback", strings.NewReader(body))
+	if timestamp != "" {
+		r.Header.Set(TimestampHeader, timestamp)
+	}
+	if sig != "" {
+		r.Header.Set(SignatureHeader, sig)
+	}
+	return r
+}
+
+func TestVerifyHandle(t *testing.T) {
+	body := `{"order":"12345678903","status":"PROCESSED","accrual":500}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{name: "valid", req: signedRequest(body, now, Sign(testSecret, now, []byte(body))), want: http.StatusOK},
+		{name: "bad signature", req: signedRequest(body, now, Sign("other", now, []byte(body))), want: http.StatusUnauthorized},
+		{name: "tampered body", req: signedRequest(body+" ", now, Sign(testSecret, now, []byte(body))), want: http.StatusUnauthorized},
+		{name: "stale timestamp", req: signedRequest(body, stale, Sign(testSecret, stale, []byte(body))), want: http.StatusUnauthorized},
+		{name: "future timestamp", req: signedRequest(body, future, Sign(testSecret, future, []byte(body))), want: http.StatusUnauthorized},
+		{name: "missing timestamp", req: signedRequest(body, "", Sign(testSecret, now, []byte(body))), want: http.StatusUnauthorized},
+		{name: "missing signature", req: signedRequest(body, now, ""), want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := VerifyHandle(testSecret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got = string(b)
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tt.req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && got != body {
+				t.Errorf("handler read body %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestVerifyHandleWithoutSecret(t *testing.T) {
+	handler := VerifyHandle("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not be called")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedRequest("{}", "", ""))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
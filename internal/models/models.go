@@ -1,48 +1,260 @@
 package models
 
 import (
+	"encoding/base64"
+	"errors"
+	"strings"
 	"time"
 )
 
 type CtxKey string
 
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrWithdrawalExists  = errors.New("withdrawal for order already exists")
+	ErrReversalExceeds   = errors.New("reversal exceeds withdrawn amount")
+	ErrHoldExpired       = errors.New("withdrawal hold expired")
+	ErrTransferLimit     = errors.New("transfer limit exceeded")
+	ErrLeaseLost         = errors.New("accrual job lease lost")
+	ErrIdempotencyLost   = errors.New("idempotency key was taken over")
+)
+
+const (
+	StatusNew        = "NEW"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+	StatusRegistered = "REGISTERED"
+)
+
+const (
+	LedgerAccrual    = "accrual"
+	LedgerWithdrawal = "withdrawal"
+	LedgerAdjustment = "adjustment"
+	LedgerReversal   = "reversal"
+	LedgerExpiry     = "expiry"
+	LedgerTransfer   = "transfer"
+	LedgerBonus      = "bonus"
+)
+
 type User struct {
 	Username string `json:"login"`
 	Password string `json:"password,omitempty"`
 }
 
 type OrderResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string `json:"order"`
+	Status  string `json:"status"`
+	Accrual Money  `json:"accrual"`
 }
 
 type Order struct {
 	Number   string    `json:"number"`
 	Status   string    `json:"status"`
-	Accrual  int       `json:"accrual,omitempty"`
+	Accrual  Money     `json:"accrual,omitempty"`
 	UploadAt time.Time `json:"upload_at"`
 }
 
 type UserBalance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
-}
-
-type OrderFloat struct {
-	Number   string    `json:"number"`
-	Status   string    `json:"status"`
-	Accrual  float64   `json:"accrual,omitempty"`
-	UploadAt time.Time `json:"upload_at"`
+	Current      Money `json:"current"`
+	Withdrawn    Money `json:"withdrawn"`
+	Held         Money `json:"held"`
+	ExpiringSoon Money `json:"expiring_soon"`
 }
 
 type UserWithdrawal struct {
 	OrderNum    string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	Sum         Money     `json:"sum"`
+	Reversed    Money     `json:"reversed,omitempty"`
 	ProcessedAt time.Time `json:"processed_at"`
+	Merchant    string    `json:"merchant,omitempty"`
+}
+
+type WithdrawRequest struct {
+	OrderNum string `json:"order"`
+	Sum      Money  `json:"sum"`
+	Merchant string `json:"merchant,omitempty"`
+	Hold     bool   `json:"hold,omitempty"`
+}
+
+const (
+	HoldHeld     = "held"
+	HoldCaptured = "captured"
+	HoldReleased = "released"
+)
+
+type WithdrawalHold struct {
+	OrderNum  string    `json:"order"`
+	User      string    `json:"-"`
+	Merchant  string    `json:"merchant,omitempty"`
+	Sum       Money     `json:"sum"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type TransferRequest struct {
+	To  string `json:"to"`
+	Sum Money  `json:"sum"`
+}
+
+type ReversalRequest struct {
+	Amount Money  `json:"amount,omitempty"`
+	Reason string `json:"reason"`
+}
+
+type WithdrawalReversal struct {
+	ID        int64     `json:"id"`
+	OrderNum  string    `json:"order"`
+	Amount    Money     `json:"amount"`
+	Remaining Money     `json:"remaining"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
-type TypeForChannel struct {
+type AccrualJob struct {
+	OrderNum   string
+	User       string
+	Attempts   int
+	NextPollAt time.Time
+	LastError  string
+}
+
+type AccrualAttempt struct {
+	Attempt     int       `json:"attempt"`
+	Error       string    `json:"error"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+type DeadLetter struct {
+	OrderNum  string           `json:"order"`
+	User      string           `json:"login"`
+	Attempts  int              `json:"attempts"`
+	LastError string           `json:"last_error"`
+	DeadAt    time.Time        `json:"dead_at"`
+	History   []AccrualAttempt `json:"history"`
+}
+
+type DeadLetterCursor struct {
+	DeadAt   time.Time
 	OrderNum string
-	User     string
+}
+
+type DeadLetterPage struct {
+	Items      []DeadLetter `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+func (c DeadLetterCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.DeadAt.Format(time.RFC3339Nano) + "|" + c.OrderNum))
+}
+
+func ParseDeadLetterCursor(s string) (DeadLetterCursor, error) {
+	var c DeadLetterCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	deadAt, order, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return c, errors.New("invalid cursor")
+	}
+	c.DeadAt, err = time.Parse(time.RFC3339Nano, deadAt)
+	c.OrderNum = order
+	return c, err
+}
+
+type AccrualDiscrepancy struct {
+	OrderNum        string    `json:"order"`
+	User            string    `json:"login"`
+	StoredAccrual   Money     `json:"stored_accrual"`
+	ReportedStatus  string    `json:"reported_status"`
+	ReportedAccrual Money     `json:"reported_accrual"`
+	DetectedAt      time.Time `json:"detected_at"`
+	CheckedAt       time.Time `json:"checked_at"`
+}
+
+type ProviderStats struct {
+	Name         string    `json:"name"`
+	Requests     int64     `json:"requests"`
+	Failures     int64     `json:"failures"`
+	RateLimited  int64     `json:"rate_limited"`
+	ServerErrors int64     `json:"server_errors"`
+	LastLatency  string    `json:"last_latency"`
+	LastRequest  time.Time `json:"last_request"`
+}
+
+type UnbalancedTransaction struct {
+	TxID int64 `json:"tx_id"`
+	Sum  Money `json:"sum"`
+}
+
+type LedgerMismatch struct {
+	User            string `json:"login"`
+	CachedCurrent   Money  `json:"cached_current"`
+	LedgerCurrent   Money  `json:"ledger_current"`
+	CachedWithdrawn Money  `json:"cached_withdrawn"`
+	LedgerWithdrawn Money  `json:"ledger_withdrawn"`
+}
+
+type LedgerReport struct {
+	Consistent bool                    `json:"consistent"`
+	Unbalanced []UnbalancedTransaction `json:"unbalanced_transactions"`
+	Mismatches []LedgerMismatch        `json:"balance_mismatches"`
+}
+
+const (
+	EntryCredit = "credit"
+	EntryDebit  = "debit"
+)
+
+type BalanceHistoryFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Type   string
+	Cursor int64
+	Limit  int
+}
+
+type BalanceEntry struct {
+	ID           int64     `json:"-"`
+	Type         string    `json:"type"`
+	Kind         string    `json:"kind"`
+	Amount       Money     `json:"amount"`
+	Balance      Money     `json:"balance"`
+	OrderNum     string    `json:"order,omitempty"`
+	Counterparty string    `json:"counterparty,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type BalanceHistory struct {
+	Items      []BalanceEntry `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type IdempotencyRecord struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	LockedAt    time.Time
+}
+
+type UserProfile struct {
+	Login           string     `json:"login"`
+	Tier            string     `json:"tier,omitempty"`
+	Multiplier      Money      `json:"multiplier"`
+	LifetimeAccrual Money      `json:"lifetime_accrual"`
+	TierUpdatedAt   *time.Time `json:"tier_updated_at,omitempty"`
+	NextTier        string     `json:"next_tier,omitempty"`
+	NextTierAt      Money      `json:"next_tier_at,omitempty"`
+}
+
+type TierChange struct {
+	User string
+	From string
+	To   string
 }
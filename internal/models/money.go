@@ -0,0 +1,137 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type Money int64
+
+var (
+	hundred = big.NewRat(100, 1)
+	two     = big.NewInt(2)
+)
+
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "/") {
+		return 0, fmt.Errorf("invalid money value %q", s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid money value %q", s)
+	}
+	r.Mul(r, hundred)
+
+	q := roundQuo(r.Num(), r.Denom())
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("money value %q is out of range", s)
+	}
+	return Money(q.Int64()), nil
+}
+
+// Mul scales m by a factor in the same two-decimal encoding, e.g. 1.25 is Money(125).
+func (m Money) Mul(factor Money) Money {
+	p := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(factor)))
+	return Money(roundQuo(p, big.NewInt(100)).Int64())
+}
+
+func roundQuo(num, denom *big.Int) *big.Int {
+	q, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if rem.Abs(rem).Mul(rem, two).Cmp(new(big.Int).Abs(denom)) >= 0 {
+		if num.Sign()*denom.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	units, cents := v/100, v%100
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return err
+		}
+		s = unquoted
+	}
+
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case string:
+		parsed, err := ParseMoney(v)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case []byte:
+		return m.Scan(string(v))
+	case int64:
+		*m = Money(v * 100)
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalText(b []byte) error {
+	parsed, err := ParseMoney(string(b))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{in: "729.98", want: 72998},
+		{in: "0", want: 0},
+		{in: "0.3", want: 30},
+		{in: "0.005", want: 1},
+		{in: "0.0049", want: 0},
+		{in: "1.125", want: 113},
+		{in: "-0.005", want: -1},
+		{in: "-729.98", want: -72998},
+		{in: "1.5e2", want: 15000},
+		{in: "7.2998E2", want: 72998},
+		{in: "5e-3", want: 1},
+		{in: " 12 ", want: 1200},
+		{in: "92233720368547758.07", want: math.MaxInt64},
+		{in: "92233720368547758.08", wantErr: true},
+		{in: "-92233720368547758.09", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "1/3", wantErr: true},
+		{in: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMoney(%q) = %d, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyAddsWithoutFloatError(t *testing.T) {
+	a, _ := ParseMoney("0.1")
+	b, _ := ParseMoney("0.2")
+
+	if sum := a + b; sum.String() != "0.3" {
+		t.Errorf("0.1 + 0.2 = %s, want 0.3", sum)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{in: 72998, want: "729.98"},
+		{in: 72990, want: "729.9"},
+		{in: 72900, want: "729"},
+		{in: 5, want: "0.05"},
+		{in: -5, want: "-0.05"},
+		{in: -72998, want: "-729.98"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %s, want %s", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMul(t *testing.T) {
+	tests := []struct {
+		m, factor, want Money
+	}{
+		{m: 72998, factor: 125, want: 91248},
+		{m: 1, factor: 150, want: 2},
+		{m: -1, factor: 150, want: -2},
+		{m: 10000, factor: 100, want: 10000},
+	}
+
+	for _, tt := range tests {
+		if got := tt.m.Mul(tt.factor); got != tt.want {
+			t.Errorf("%s * %s = %s, want %s", tt.m, tt.factor, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	for _, in := range []string{`729.98`, `-0.05`, `0`, `"729.98"`} {
+		var m Money
+		if err := json.Unmarshal([]byte(in), &m); err != nil {
+			t.Fatalf("unmarshal %s: %v", in, err)
+		}
+
+		out, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var back Money
+		if err := json.Unmarshal(out, &back); err != nil || back != m {
+			t.Errorf("round trip of %s: got %s (%d), want %d", in, out, int64(back), int64(m))
+		}
+	}
+
+	var m Money
+	if err := json.Unmarshal([]byte(`92233720368547758.08`), &m); err == nil {
+		t.Error("out of range JSON value must fail")
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src  any
+		want Money
+	}{
+		{src: "729.98", want: 72998},
+		{src: []byte("729.98"), want: 72998},
+		{src: "-0.05", want: -5},
+		{src: int64(7), want: 700},
+		{src: nil, want: 0},
+	}
+
+	for _, tt := range tests {
+		m := Money(42)
+		if err := m.Scan(tt.src); err != nil || m != tt.want {
+			t.Errorf("Scan(%#v) = %d, %v, want %d", tt.src, int64(m), err, tt.want)
+		}
+	}
+
+	var m Money
+	if err := m.Scan(1.5); err == nil {
+		t.Error("Scan of float64 must fail")
+	}
+
+	value, err := Money(72998).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Scan(value); err != nil || m != 72998 {
+		t.Errorf("Value/Scan round trip = %d, %v, want 72998", int64(m), err)
+	}
+}
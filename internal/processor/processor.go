@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+type Storage interface {
+	UpdateStatus(ctx context.Context, newStatus, order, user string) error
+	UpdateOrderProgress(ctx context.Context, order string, accrual models.Money) (bool, error)
+	CompleteAccrualJob(ctx context.Context, owner, order string) error
+}
+
+type Processor struct {
+	storage Storage
+	logger  *logging.Logger
+}
+
+func NewProcessor(storage Storage, logger *logging.Logger) *Processor {
+	return &Processor{storage: storage, logger: logger}
+}
+
+func (p *Processor) Apply(ctx context.Context, order, user string, response models.OrderResponse) (bool, error) {
+	return p.ApplyLeased(ctx, "", order, user, response)
+}
+
+// ApplyLeased completes the job only while owner still holds its lease.
+func (p *Processor) ApplyLeased(ctx context.Context, owner, order, user string, response models.OrderResponse) (bool, error) {
+	var err error
+
+	switch response.Status {
+	case models.StatusRegistered:
+		err = p.storage.UpdateStatus(ctx, models.StatusNew, order, user)
+	case models.StatusProcessing, models.StatusInvalid:
+		err = p.storage.UpdateStatus(ctx, response.Status, order, user)
+	case models.StatusProcessed:
+		if response.Accrual < 0 {
+			return false, fmt.Errorf("negative accrual %s for order %s", response.Accrual, order)
+		}
+		var credited bool
+		credited, err = p.storage.UpdateOrderProgress(ctx, order, response.Accrual)
+		if err == nil && !credited {
+			p.logger.Logger.Infow("Order accrual already applied", "order", order)
+		}
+	default:
+		return false, fmt.Errorf("unknown accrual status: %q", response.Status)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error in update db: %w", err)
+	}
+
+	final := response.Status == models.StatusInvalid || response.Status == models.StatusProcessed
+	if !final {
+		return false, nil
+	}
+
+	err = p.storage.CompleteAccrualJob(ctx, owner, order)
+	if errors.Is(err, models.ErrLeaseLost) {
+		p.logger.Logger.Warnw("Accrual job lease lost before completion", "order", order, "owner", owner)
+	} else if err != nil {
+		p.logger.Logger.Errorw("Failed to complete accrual job", "order", order, "err", err)
+	}
+	return true, nil
+}
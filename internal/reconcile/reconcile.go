@@ -0,0 +1,75 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sinfirst/Ref-System/internal/accrual"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+	"github.com/sinfirst/Ref-System/internal/storage/pg"
+)
+
+const LockKey int64 = 4202
+
+// notRegistered is the reported status of a processed order the accrual system does not know.
+const notRegistered = "NOT_REGISTERED"
+
+type AccrualClient interface {
+	GetOrderAccrual(ctx context.Context, order string) (models.OrderResponse, error)
+}
+
+type Reconciler struct {
+	db     *pg.PGDB
+	client AccrualClient
+	logger *logging.Logger
+	sample int
+}
+
+func NewReconciler(db *pg.PGDB, client AccrualClient, logger *logging.Logger, sample int) *Reconciler {
+	return &Reconciler{db: db, client: client, logger: logger, sample: sample}
+}
+
+func (r *Reconciler) Run(ctx context.Context) error {
+	orders, err := r.db.GetProcessedOrders(ctx, r.sample)
+	if err != nil {
+		return err
+	}
+
+	var checked, diverged, unregistered, skipped int
+	for _, order := range orders {
+		response, err := r.client.GetOrderAccrual(ctx, order.Number)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, accrual.ErrOrderNotRegistered) {
+			unregistered++
+			response, err = models.OrderResponse{Order: order.Number, Status: notRegistered}, nil
+		}
+		if err != nil {
+			r.logger.Logger.Warnw("Failed to reconcile order", "order", order.Number, "err", err)
+			skipped++
+			continue
+		}
+		checked++
+
+		if response.Status == models.StatusProcessed && response.Accrual == order.Accrual {
+			err = r.db.ResolveAccrualDiscrepancy(ctx, order.Number)
+		} else {
+			diverged++
+			err = r.db.SaveAccrualDiscrepancy(ctx, models.AccrualDiscrepancy{
+				OrderNum:        order.Number,
+				StoredAccrual:   order.Accrual,
+				ReportedStatus:  response.Status,
+				ReportedAccrual: response.Accrual,
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	r.logger.Logger.Infow("Accrual reconciliation finished",
+		"checked", checked, "diverged", diverged, "unregistered", unregistered, "skipped", skipped)
+	return nil
+}
@@ -3,22 +3,52 @@ package router
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/sinfirst/Ref-System/internal/app"
+	"github.com/sinfirst/Ref-System/internal/config"
 	"github.com/sinfirst/Ref-System/internal/middleware/auth"
 	"github.com/sinfirst/Ref-System/internal/middleware/compress"
+	"github.com/sinfirst/Ref-System/internal/middleware/idempotency"
 	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/middleware/signature"
 )
 
-func NewRouter(a *app.App, logger *logging.Logger) *chi.Mux {
+func NewRouter(a *app.App, conf config.Config, logger *logging.Logger, keys idempotency.Store) *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(logger.WithLogging)
+	idempotent := idempotency.Handle(keys, logger)
 	router.With(compress.DecompressHandle).Post("/api/user/register", a.Register)
 	router.With(compress.DecompressHandle).Post("/api/user/login", a.Login)
-	router.With(compress.DecompressHandle, auth.AuthMiddleware).Post("/api/user/orders", a.OrdersIn)
-	router.With(compress.DecompressHandle, auth.AuthMiddleware).Post("/api/user/balance/withdraw", a.Withdraw)
+	router.With(compress.DecompressHandle, auth.AuthMiddleware, idempotent).Post("/api/user/orders", a.OrdersIn)
+	router.With(compress.DecompressHandle, auth.AuthMiddleware, idempotent).Post("/api/user/balance/withdraw", a.Withdraw)
+	router.With(compress.DecompressHandle, auth.AuthMiddleware, idempotent).Post("/api/user/balance/transfer", a.Transfer)
+	router.With(auth.AuthMiddleware).Post("/api/user/balance/holds/{order}/capture", a.CaptureHold)
+	router.With(auth.AuthMiddleware).Post("/api/user/balance/holds/{order}/release", a.ReleaseHold)
 
 	router.With(compress.CompressHandle, auth.AuthMiddleware).Get("/api/user/orders", a.OrdersInfo)
 	router.With(compress.CompressHandle, auth.AuthMiddleware).Get("/api/user/balance", a.GetBalance)
+	router.With(compress.CompressHandle, auth.AuthMiddleware).Get("/api/user/balance/history", a.BalanceHistory)
 	router.With(compress.CompressHandle, auth.AuthMiddleware).Get("/api/user/withdrawals", a.WithdrawInfo)
+	router.With(compress.CompressHandle, auth.AuthMiddleware).Get("/api/user/profile", a.Profile)
+
+	if conf.AccrualPushEnabled() {
+		router.With(signature.VerifyHandle(conf.AccrualCallbackSecret)).Post("/api/internal/accrual/callback", a.AccrualCallback)
+	}
+
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Use(auth.AdminMiddleware(conf.AdminToken))
+		r.With(compress.CompressHandle).Get("/accrual/dead-letters", a.DeadLetters)
+		r.Post("/accrual/dead-letters/{number}/requeue", a.RequeueDeadLetter)
+		r.With(compress.CompressHandle).Get("/accrual/discrepancies", a.AccrualDiscrepancies)
+		r.With(compress.CompressHandle).Get("/accrual/providers", a.AccrualProviders)
+		r.With(compress.CompressHandle).Get("/ledger/check", a.LedgerCheck)
+		r.With(compress.DecompressHandle).Post("/withdrawals/{order}/reverse", a.AdminReverseWithdrawal)
+	})
+
+	router.Route("/api/merchant", func(r chi.Router) {
+		r.Use(auth.MerchantMiddleware(conf.MerchantTokens))
+		r.With(compress.DecompressHandle).Post("/withdrawals/{order}/reverse", a.MerchantReverseWithdrawal)
+		r.Post("/holds/{order}/capture", a.MerchantCaptureHold)
+		r.Post("/holds/{order}/release", a.MerchantReleaseHold)
+	})
 
 	return router
 }
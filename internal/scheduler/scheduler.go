@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+)
+
+const maxCheckInterval = time.Minute
+
+type Store interface {
+	TryAdvisoryLock(ctx context.Context, key int64) (func(), bool, error)
+	LastJobRun(ctx context.Context, name string) (time.Time, bool, error)
+	SaveJobRun(ctx context.Context, name string, at time.Time) error
+}
+
+type Job struct {
+	Name     string
+	LockKey  int64
+	Interval time.Duration
+	// RunAtStart runs the job once on startup even if its interval has not passed yet.
+	RunAtStart bool
+	Run        func(ctx context.Context) error
+}
+
+type Scheduler struct {
+	store  Store
+	logger *logging.Logger
+	wg     sync.WaitGroup
+}
+
+func New(store Store, logger *logging.Logger) *Scheduler {
+	return &Scheduler{store: store, logger: logger}
+}
+
+func (s *Scheduler) Start(ctx context.Context, job Job) {
+	if job.Interval <= 0 {
+		s.logger.Logger.Infow("Scheduled job is disabled", "job", job.Name)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx, job)
+	}()
+}
+
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Every replica checks the job on its own ticker, the last run stored in the database
+// keeps it to one run per interval across all of them.
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	check := min(job.Interval, maxCheckInterval)
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+
+	force := job.RunAtStart
+	for {
+		if s.runOnce(ctx, job, force) {
+			force = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job Job, force bool) bool {
+	release, ok, err := s.store.TryAdvisoryLock(ctx, job.LockKey)
+	if err != nil {
+		s.logger.Logger.Errorw("Failed to take scheduled job lock", "job", job.Name, "err", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	defer release()
+
+	lastRun, ran, err := s.store.LastJobRun(ctx, job.Name)
+	if err != nil {
+		s.logger.Logger.Errorw("Failed to get scheduled job last run", "job", job.Name, "err", err)
+		return false
+	}
+	if !force && ran && time.Since(lastRun) < job.Interval {
+		return false
+	}
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		s.logger.Logger.Errorw("Scheduled job failed", "job", job.Name, "err", err)
+		return false
+	}
+	if err := s.store.SaveJobRun(ctx, job.Name, start); err != nil {
+		s.logger.Logger.Errorw("Failed to save scheduled job run", "job", job.Name, "err", err)
+	}
+	s.logger.Logger.Infow("Scheduled job finished", "job", job.Name, "duration", time.Since(start))
+	return true
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+)
+
+type memStore struct {
+	mu     sync.Mutex
+	locked map[int64]bool
+	runs   map[string]time.Time
+}
+
+func newMemStore() *memStore {
+	return &memStore{locked: make(map[int64]bool), runs: make(map[string]time.Time)}
+}
+
+func (m *memStore) TryAdvisoryLock(ctx context.Context, key int64) (func(), bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.locked[key] {
+		return nil, false, nil
+	}
+	m.locked[key] = true
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.locked, key)
+	}, true, nil
+}
+
+func (m *memStore) LastJobRun(ctx context.Context, name string) (time.Time, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	at, ok := m.runs[name]
+	return at, ok, nil
+}
+
+func (m *memStore) SaveJobRun(ctx context.Context, name string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs[name] = at
+	return nil
+}
+
+func TestJobRunsOncePerIntervalAcrossReplicas(t *testing.T) {
+	store := newMemStore()
+	logger := logging.NewLogger()
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	var runs atomic.Int32
+	job := Job{
+		Name:     "test",
+		LockKey:  1,
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		},
+	}
+
+	replicas := []*Scheduler{New(store, logger), New(store, logger), New(store, logger)}
+	for _, s := range replicas {
+		s.Start(ctx, job)
+	}
+	<-ctx.Done()
+	for _, s := range replicas {
+		s.Wait()
+	}
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("job ran %d times, want 1", got)
+	}
+}
+
+func TestRunAtStartIgnoresRecentRun(t *testing.T) {
+	store := newMemStore()
+	store.runs["test"] = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var runs atomic.Int32
+	s := New(store, logging.NewLogger())
+	s.Start(ctx, Job{
+		Name:       "test",
+		LockKey:    1,
+		Interval:   time.Hour,
+		RunAtStart: true,
+		Run: func(ctx context.Context) error {
+			runs.Add(1)
+			return nil
+		},
+	})
+	<-ctx.Done()
+	s.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("job ran %d times, want 1", got)
+	}
+}
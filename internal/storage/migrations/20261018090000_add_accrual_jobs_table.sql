@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS accrual_jobs (
+    order_number TEXT NOT NULL PRIMARY KEY REFERENCES orders(number) ON DELETE CASCADE,
+    username TEXT REFERENCES users(username) ON DELETE SET NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_poll_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS accrual_jobs_next_poll_at_idx ON accrual_jobs (next_poll_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS accrual_jobs;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS accrual_job_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    order_number TEXT NOT NULL REFERENCES orders(number) ON DELETE CASCADE,
+    attempt INT NOT NULL,
+    error TEXT,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS accrual_job_attempts_order_number_idx ON accrual_job_attempts (order_number);
+
+CREATE TABLE IF NOT EXISTS accrual_dead_letters (
+    order_number TEXT NOT NULL PRIMARY KEY REFERENCES orders(number) ON DELETE CASCADE,
+    username TEXT REFERENCES users(username) ON DELETE SET NULL,
+    attempts INT NOT NULL,
+    last_error TEXT,
+    dead_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS accrual_dead_letters;
+DROP TABLE IF EXISTS accrual_job_attempts;
+-- +goose StatementEnd
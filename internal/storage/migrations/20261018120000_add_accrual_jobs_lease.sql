@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE accrual_jobs ADD COLUMN IF NOT EXISTS locked_by TEXT;
+ALTER TABLE accrual_jobs ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE accrual_jobs DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE accrual_jobs DROP COLUMN IF EXISTS locked_by;
+-- +goose StatementEnd
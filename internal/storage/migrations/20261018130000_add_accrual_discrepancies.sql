@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS accrual_discrepancies (
+    order_number TEXT NOT NULL PRIMARY KEY REFERENCES orders(number) ON DELETE CASCADE,
+    username TEXT REFERENCES users(username) ON DELETE SET NULL,
+    stored_accrual INT NOT NULL,
+    reported_status TEXT NOT NULL,
+    reported_accrual INT NOT NULL,
+    detected_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    checked_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS accrual_discrepancies;
+-- +goose StatementEnd
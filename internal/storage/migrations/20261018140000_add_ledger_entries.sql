@@ -0,0 +1,65 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE SEQUENCE IF NOT EXISTS ledger_tx_seq;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    tx_id BIGINT NOT NULL,
+    account TEXT NOT NULL,
+    username TEXT REFERENCES users(username) ON DELETE SET NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('accrual', 'withdrawal', 'adjustment', 'reversal')),
+    amount INT NOT NULL,
+    order_number TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_account_idx ON ledger_entries (account, id);
+CREATE INDEX IF NOT EXISTS ledger_entries_tx_id_idx ON ledger_entries (tx_id);
+
+CREATE OR REPLACE FUNCTION ledger_entries_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+CREATE TRIGGER ledger_entries_append_only
+    BEFORE UPDATE OF tx_id, account, kind, amount, order_number OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_entries_append_only();
+
+WITH withdrawn AS (
+    SELECT username, SUM(sum) AS total FROM withdrawals WHERE username IS NOT NULL GROUP BY username
+), opening AS MATERIALIZED (
+    SELECT nextval('ledger_tx_seq') AS tx_id, u.username, COALESCE(u.accrual, 0) + COALESCE(w.total, 0) AS amount
+    FROM users u LEFT JOIN withdrawn w ON w.username = u.username
+    WHERE COALESCE(u.accrual, 0) + COALESCE(w.total, 0) <> 0
+)
+INSERT INTO ledger_entries (tx_id, account, username, kind, amount)
+SELECT o.tx_id, leg.account, leg.username, 'adjustment', leg.amount
+FROM opening o
+CROSS JOIN LATERAL (VALUES
+    ('user:' || o.username, o.username, o.amount),
+    ('system:adjustments', NULL, -o.amount)
+) AS leg(account, username, amount);
+
+WITH debits AS MATERIALIZED (
+    SELECT nextval('ledger_tx_seq') AS tx_id, username, orderNum, sum, precessed_at
+    FROM withdrawals WHERE username IS NOT NULL AND sum <> 0
+)
+INSERT INTO ledger_entries (tx_id, account, username, kind, amount, order_number, created_at)
+SELECT d.tx_id, leg.account, leg.username, 'withdrawal', leg.amount, d.orderNum, d.precessed_at
+FROM debits d
+CROSS JOIN LATERAL (VALUES
+    ('user:' || d.username, d.username, -d.sum),
+    ('system:withdrawals', NULL, d.sum)
+) AS leg(account, username, amount);
+
+UPDATE users u SET withdrawn = COALESCE((SELECT SUM(sum) FROM withdrawals w WHERE w.username = u.username), 0);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ledger_entries;
+DROP FUNCTION IF EXISTS ledger_entries_append_only();
+DROP SEQUENCE IF EXISTS ledger_tx_seq;
+-- +goose StatementEnd
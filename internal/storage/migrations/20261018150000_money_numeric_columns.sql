@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+
+ALTER TABLE users
+    ALTER COLUMN accrual TYPE NUMERIC(20, 2) USING accrual / 100.0,
+    ALTER COLUMN withdrawn TYPE NUMERIC(20, 2) USING withdrawn / 100.0;
+
+ALTER TABLE orders
+    ALTER COLUMN accrual TYPE NUMERIC(20, 2) USING accrual / 100.0;
+
+ALTER TABLE withdrawals
+    ALTER COLUMN sum TYPE NUMERIC(20, 2) USING sum / 100.0;
+
+ALTER TABLE accrual_discrepancies
+    ALTER COLUMN stored_accrual TYPE NUMERIC(20, 2) USING stored_accrual / 100.0,
+    ALTER COLUMN reported_accrual TYPE NUMERIC(20, 2) USING reported_accrual / 100.0;
+
+ALTER TABLE ledger_entries
+    ALTER COLUMN amount TYPE NUMERIC(20, 2) USING amount / 100.0;
+
+CREATE TRIGGER ledger_entries_append_only
+    BEFORE UPDATE OF tx_id, account, kind, amount, order_number OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_entries_append_only();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+
+ALTER TABLE ledger_entries
+    ALTER COLUMN amount TYPE INT USING round(amount * 100)::INT;
+
+ALTER TABLE accrual_discrepancies
+    ALTER COLUMN stored_accrual TYPE INT USING round(stored_accrual * 100)::INT,
+    ALTER COLUMN reported_accrual TYPE INT USING round(reported_accrual * 100)::INT;
+
+ALTER TABLE withdrawals
+    ALTER COLUMN sum TYPE INT USING round(sum * 100)::INT;
+
+ALTER TABLE orders
+    ALTER COLUMN accrual TYPE INT USING round(accrual * 100)::INT;
+
+ALTER TABLE users
+    ALTER COLUMN accrual TYPE INT USING round(accrual * 100)::INT,
+    ALTER COLUMN withdrawn TYPE INT USING round(withdrawn * 100)::INT;
+
+CREATE TRIGGER ledger_entries_append_only
+    BEFORE UPDATE OF tx_id, account, kind, amount, order_number OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_entries_append_only();
+-- +goose StatementEnd
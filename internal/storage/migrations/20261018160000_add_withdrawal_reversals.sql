@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS withdrawal_reversals (
+    id BIGSERIAL PRIMARY KEY,
+    order_number TEXT NOT NULL REFERENCES withdrawals(orderNum) ON DELETE CASCADE,
+    username TEXT REFERENCES users(username) ON DELETE SET NULL,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    reason TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS withdrawal_reversals_order_idx ON withdrawal_reversals (order_number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS withdrawal_reversals;
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS accrual_lots (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL REFERENCES users(username) ON DELETE CASCADE,
+    order_number TEXT,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    remaining NUMERIC(20, 2) NOT NULL CHECK (remaining >= 0 AND remaining <= amount),
+    credited_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS accrual_lots_open_idx ON accrual_lots (username, credited_at, id) WHERE remaining > 0;
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_kind_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('accrual', 'withdrawal', 'adjustment', 'reversal', 'expiry'));
+
+-- Existing balances become a single lot dated at upgrade time, so nothing expires on deploy.
+INSERT INTO accrual_lots (username, amount, remaining)
+SELECT username, balance, balance
+FROM (
+    SELECT username, SUM(amount) AS balance FROM ledger_entries
+    WHERE username IS NOT NULL AND account = 'user:' || username
+    GROUP BY username
+) b
+WHERE balance > 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS accrual_lots;
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_kind_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('accrual', 'withdrawal', 'adjustment', 'reversal')) NOT VALID;
+-- +goose StatementEnd
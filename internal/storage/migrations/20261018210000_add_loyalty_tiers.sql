@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS tier_multiplier NUMERIC(6, 2) NOT NULL DEFAULT 1 CHECK (tier_multiplier > 0),
+    ADD COLUMN IF NOT EXISTS tier_updated_at TIMESTAMPTZ;
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_kind_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('accrual', 'withdrawal', 'adjustment', 'reversal', 'expiry', 'transfer', 'bonus'));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_kind_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_kind_check
+    CHECK (kind IN ('accrual', 'withdrawal', 'adjustment', 'reversal', 'expiry', 'transfer')) NOT VALID;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS tier,
+    DROP COLUMN IF EXISTS tier_multiplier,
+    DROP COLUMN IF EXISTS tier_updated_at;
+-- +goose StatementEnd
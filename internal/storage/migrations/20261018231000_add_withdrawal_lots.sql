@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS withdrawal_lots (
+    id BIGSERIAL PRIMARY KEY,
+    order_number TEXT NOT NULL REFERENCES withdrawals(orderNum) ON DELETE CASCADE,
+    lot_order TEXT,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    restored NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (restored >= 0 AND restored <= amount),
+    credited_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS withdrawal_lots_order_idx ON withdrawal_lots (order_number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS withdrawal_lots;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE withdrawal_holds ADD COLUMN IF NOT EXISTS merchant TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE withdrawal_holds DROP COLUMN IF EXISTS merchant;
+-- +goose StatementEnd
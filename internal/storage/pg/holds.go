@@ -0,0 +1,211 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func heldAmount(ctx context.Context, q querier, user string) (models.Money, error) {
+	var held models.Money
+	query := `SELECT COALESCE(SUM(amount), 0) FROM withdrawal_holds
+				WHERE username = $1 AND status = 'held' AND expires_at > now()`
+	err := q.QueryRow(ctx, query, user).Scan(&held)
+
+	return held, err
+}
+
+func availableBalance(ctx context.Context, q querier, user string) (models.Money, error) {
+	current, _, err := ledgerBalance(ctx, q, user)
+	if err != nil {
+		return 0, err
+	}
+
+	held, err := heldAmount(ctx, q, user)
+	if err != nil {
+		return 0, err
+	}
+
+	return current - held, nil
+}
+
+// HoldWithdrawal can hold an order again after its previous hold was released, as Withdraw can withdraw it.
+func (p *PGDB) HoldWithdrawal(ctx context.Context, user, orderNum, merchant string, sum models.Money, ttl time.Duration) (models.WithdrawalHold, error) {
+	hold := models.WithdrawalHold{OrderNum: orderNum, Merchant: merchant, Sum: sum, Status: models.HoldHeld}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return hold, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, user)
+	if err != nil {
+		return hold, fmt.Errorf("failed to lock user balance: %w", err)
+	}
+
+	available, err := availableBalance(ctx, tx, user)
+	if err != nil {
+		return hold, fmt.Errorf("failed to get user balance: %w", err)
+	}
+
+	if available < sum {
+		return hold, models.ErrInsufficientFunds
+	}
+
+	var withdrawn bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM withdrawals WHERE orderNum = $1)`, orderNum).Scan(&withdrawn)
+	if err != nil {
+		return hold, fmt.Errorf("failed to check withdrawals: %w", err)
+	}
+	if withdrawn {
+		return hold, models.ErrWithdrawalExists
+	}
+
+	query := `INSERT INTO withdrawal_holds (order_number, username, amount, expires_at, merchant)
+				VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+				ON CONFLICT (order_number) DO UPDATE
+				SET username = EXCLUDED.username, amount = EXCLUDED.amount, status = 'held', created_at = now(),
+					expires_at = EXCLUDED.expires_at, resolved_at = NULL, merchant = EXCLUDED.merchant
+				WHERE withdrawal_holds.status = 'released'
+				RETURNING created_at, expires_at`
+	err = tx.QueryRow(ctx, query, orderNum, user, sum, time.Now().Add(ttl), merchant).Scan(&hold.CreatedAt, &hold.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hold, models.ErrWithdrawalExists
+	}
+	if err != nil {
+		return hold, fmt.Errorf("failed to insert withdrawal hold: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return hold, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return hold, nil
+}
+
+func (p *PGDB) CaptureHold(ctx context.Context, user, merchant, orderNum string) (models.WithdrawalHold, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return models.WithdrawalHold{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	hold, err := lockHold(ctx, tx, user, merchant, orderNum)
+	if err != nil {
+		return hold, err
+	}
+
+	if !hold.ExpiresAt.After(time.Now()) {
+		_, err = tx.Exec(ctx, `UPDATE withdrawal_holds SET status = 'released', resolved_at = now()
+				WHERE order_number = $1`, orderNum)
+		if err != nil {
+			return hold, fmt.Errorf("failed to release withdrawal hold: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return hold, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return hold, models.ErrHoldExpired
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE withdrawal_holds SET status = 'captured', resolved_at = now()
+				WHERE order_number = $1`, orderNum)
+	if err != nil {
+		return hold, fmt.Errorf("failed to capture withdrawal hold: %w", err)
+	}
+
+	available, err := availableBalance(ctx, tx, hold.User)
+	if err != nil {
+		return hold, fmt.Errorf("failed to get user balance: %w", err)
+	}
+	if available < hold.Sum {
+		return hold, models.ErrInsufficientFunds
+	}
+
+	if err := postWithdrawal(ctx, tx, hold.User, orderNum, hold.Merchant, hold.Sum); err != nil {
+		return hold, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return hold, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	hold.Status = models.HoldCaptured
+	return hold, nil
+}
+
+func (p *PGDB) ReleaseHold(ctx context.Context, user, merchant, orderNum string) (models.WithdrawalHold, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return models.WithdrawalHold{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	hold, err := lockHold(ctx, tx, user, merchant, orderNum)
+	if err != nil {
+		return hold, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE withdrawal_holds SET status = 'released', resolved_at = now()
+				WHERE order_number = $1`, orderNum)
+	if err != nil {
+		return hold, fmt.Errorf("failed to release withdrawal hold: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return hold, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	hold.Status = models.HoldReleased
+	return hold, nil
+}
+
+// lockHold takes the user row lock before the hold row, the same order Withdraw uses.
+// An empty user matches a hold of any user. The merchant must match exactly, so users resolve only
+// their own holds and a merchant hold is resolved by that merchant or the TTL release.
+func lockHold(ctx context.Context, tx pgx.Tx, user, merchant, orderNum string) (models.WithdrawalHold, error) {
+	var hold models.WithdrawalHold
+
+	err := tx.QueryRow(ctx, `SELECT username FROM withdrawal_holds
+				WHERE order_number = $1 AND status = 'held' AND ($2 = '' OR username = $2) AND COALESCE(merchant, '') = $3`,
+		orderNum, user, merchant).Scan(&hold.User)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hold, models.ErrNotFound
+	}
+	if err != nil {
+		return hold, fmt.Errorf("failed to find withdrawal hold: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, hold.User)
+	if err != nil {
+		return hold, fmt.Errorf("failed to lock user balance: %w", err)
+	}
+
+	// The hold may have been released and held again by someone else before the user lock was taken.
+	query := `SELECT order_number, COALESCE(merchant, ''), amount, status, created_at, expires_at FROM withdrawal_holds
+				WHERE order_number = $1 AND status = 'held' AND username = $2 AND COALESCE(merchant, '') = $3 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, orderNum, hold.User, merchant).Scan(&hold.OrderNum, &hold.Merchant, &hold.Sum, &hold.Status,
+		&hold.CreatedAt, &hold.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hold, models.ErrNotFound
+	}
+	if err != nil {
+		return hold, fmt.Errorf("failed to lock withdrawal hold: %w", err)
+	}
+
+	return hold, nil
+}
+
+func (p *PGDB) ReleaseExpiredHolds(ctx context.Context) (int64, error) {
+	res, err := p.db.Exec(ctx, `UPDATE withdrawal_holds SET status = 'released', resolved_at = now()
+				WHERE status = 'held' AND expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,77 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+func (p *PGDB) BeginIdempotencyKey(ctx context.Context, user, key, hash string, staleBefore time.Time) (models.IdempotencyRecord, bool, error) {
+	var record models.IdempotencyRecord
+
+	query := `INSERT INTO idempotency_keys (username, key, request_hash) VALUES ($1, $2, $3)
+				ON CONFLICT (username, key) DO UPDATE SET locked_at = now()
+				WHERE idempotency_keys.status_code IS NULL
+					AND idempotency_keys.request_hash = EXCLUDED.request_hash
+					AND idempotency_keys.locked_at < $4
+				RETURNING request_hash, locked_at`
+	err := p.db.QueryRow(ctx, query, user, key, hash, staleBefore).Scan(&record.RequestHash, &record.LockedAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return record, false, fmt.Errorf("failed to lock idempotency key: %w", err)
+	}
+
+	query = `SELECT request_hash, COALESCE(status_code, 0), content_type, body
+				FROM idempotency_keys WHERE username = $1 AND key = $2`
+	err = p.db.QueryRow(ctx, query, user, key).Scan(&record.RequestHash, &record.StatusCode, &record.ContentType,
+		&record.Body)
+	if err != nil {
+		return record, false, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+
+	return record, false, nil
+}
+
+// CompleteIdempotencyKey and ReleaseIdempotencyKey return ErrIdempotencyLost when the key was taken
+// over as stale after lockedAt, the request that holds it now stores its own response.
+func (p *PGDB) CompleteIdempotencyKey(ctx context.Context, user, key string, lockedAt time.Time, status int, contentType string, body []byte) error {
+	query := `UPDATE idempotency_keys SET status_code = $4, content_type = $5, body = $6
+				WHERE username = $1 AND key = $2 AND locked_at = $3 AND status_code IS NULL`
+	res, err := p.db.Exec(ctx, query, user, key, lockedAt, status, contentType, body)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return models.ErrIdempotencyLost
+	}
+
+	return nil
+}
+
+func (p *PGDB) ReleaseIdempotencyKey(ctx context.Context, user, key string, lockedAt time.Time) error {
+	query := `DELETE FROM idempotency_keys WHERE username = $1 AND key = $2 AND locked_at = $3 AND status_code IS NULL`
+	res, err := p.db.Exec(ctx, query, user, key, lockedAt)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return models.ErrIdempotencyLost
+	}
+
+	return nil
+}
+
+func (p *PGDB) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	res, err := p.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected(), nil
+}
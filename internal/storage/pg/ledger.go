@@ -0,0 +1,183 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const (
+	accountAccrual     = "system:accrual"
+	accountWithdrawals = "system:withdrawals"
+)
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type ledgerLeg struct {
+	account string
+	user    string
+	amount  models.Money
+}
+
+func userAccount(user string) string {
+	return "user:" + user
+}
+
+func userLeg(user string, amount models.Money) ledgerLeg {
+	return ledgerLeg{account: userAccount(user), user: user, amount: amount}
+}
+
+func systemLeg(account string, amount models.Money) ledgerLeg {
+	return ledgerLeg{account: account, amount: amount}
+}
+
+func postLedger(ctx context.Context, tx pgx.Tx, kind, order string, legs ...ledgerLeg) error {
+	var total models.Money
+	for _, leg := range legs {
+		total += leg.amount
+	}
+	if total != 0 {
+		return fmt.Errorf("unbalanced ledger transaction: %s", total)
+	}
+
+	var txID int64
+	if err := tx.QueryRow(ctx, `SELECT nextval('ledger_tx_seq')`).Scan(&txID); err != nil {
+		return fmt.Errorf("failed to allocate ledger transaction: %w", err)
+	}
+
+	query := `INSERT INTO ledger_entries (tx_id, account, username, kind, amount, order_number)
+				VALUES ($1, $2, NULLIF($3, ''), $4, $5, NULLIF($6, ''))`
+	for _, leg := range legs {
+		_, err := tx.Exec(ctx, query, txID, leg.account, leg.user, kind, leg.amount, order)
+		if err != nil {
+			return fmt.Errorf("failed to post ledger entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func ledgerBalance(ctx context.Context, q querier, user string) (models.Money, models.Money, error) {
+	var current, withdrawn models.Money
+	query := `SELECT COALESCE(SUM(l.amount), 0),
+					COALESCE(-SUM(l.amount) FILTER (WHERE l.kind IN ('withdrawal', 'reversal')), 0)
+				FROM users u
+				LEFT JOIN ledger_entries l ON l.account = 'user:' || u.username
+				WHERE u.username = $1
+				GROUP BY u.username`
+	err := q.QueryRow(ctx, query, user).Scan(&current, &withdrawn)
+
+	return current, withdrawn, err
+}
+
+func (p *PGDB) CheckLedger(ctx context.Context) (models.LedgerReport, error) {
+	report := models.LedgerReport{}
+
+	rows, err := p.db.Query(ctx, `SELECT tx_id, SUM(amount) FROM ledger_entries
+		GROUP BY tx_id HAVING SUM(amount) <> 0 ORDER BY tx_id`)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var t models.UnbalancedTransaction
+
+		if err := rows.Scan(&t.TxID, &t.Sum); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.Unbalanced = append(report.Unbalanced, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	query := `SELECT u.username, COALESCE(u.accrual, 0), COALESCE(u.withdrawn, 0), b.current, b.withdrawn
+				FROM users u
+				CROSS JOIN LATERAL (
+					SELECT COALESCE(SUM(l.amount), 0) AS current,
+						COALESCE(-SUM(l.amount) FILTER (WHERE l.kind IN ('withdrawal', 'reversal')), 0) AS withdrawn
+					FROM ledger_entries l WHERE l.account = 'user:' || u.username
+				) b
+				WHERE COALESCE(u.accrual, 0) <> b.current OR COALESCE(u.withdrawn, 0) <> b.withdrawn
+				ORDER BY u.username`
+	rows, err = p.db.Query(ctx, query)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.LedgerMismatch
+
+		err := rows.Scan(&m.User, &m.CachedCurrent, &m.CachedWithdrawn, &m.LedgerCurrent, &m.LedgerWithdrawn)
+		if err != nil {
+			return report, err
+		}
+
+		report.Mismatches = append(report.Mismatches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	report.Consistent = len(report.Unbalanced) == 0 && len(report.Mismatches) == 0
+	return report, nil
+}
+
+func (p *PGDB) GetBalanceHistory(ctx context.Context, user string, filter models.BalanceHistoryFilter) (models.BalanceHistory, error) {
+	history := models.BalanceHistory{}
+
+	query := `SELECT id, kind, amount, balance, COALESCE(order_number, ''),
+					COALESCE((SELECT o.username FROM ledger_entries o
+						WHERE h.kind = 'transfer' AND o.tx_id = h.tx_id AND o.id <> h.id LIMIT 1), ''),
+					created_at
+				FROM (
+					SELECT id, tx_id, kind, amount, order_number, created_at,
+						SUM(amount) OVER (ORDER BY id) AS balance
+					FROM ledger_entries WHERE account = $1
+				) h
+				WHERE id > $2
+					AND ($3::TIMESTAMPTZ IS NULL OR created_at >= $3)
+					AND ($4::TIMESTAMPTZ IS NULL OR created_at < $4)
+					AND ($5 = '' OR ($5 = 'credit' AND amount > 0) OR ($5 = 'debit' AND amount < 0))
+				ORDER BY id
+				LIMIT $6`
+	rows, err := p.db.Query(ctx, query, userAccount(user), filter.Cursor, filter.From, filter.To, filter.Type,
+		filter.Limit+1)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.BalanceEntry
+
+		err := rows.Scan(&e.ID, &e.Kind, &e.Amount, &e.Balance, &e.OrderNum, &e.Counterparty, &e.CreatedAt)
+		if err != nil {
+			return history, err
+		}
+
+		e.Type = models.EntryCredit
+		if e.Amount < 0 {
+			e.Type = models.EntryDebit
+			e.Amount = -e.Amount
+		}
+		history.Items = append(history.Items, e)
+	}
+	if err := rows.Err(); err != nil {
+		return history, err
+	}
+
+	if len(history.Items) > filter.Limit {
+		history.Items = history.Items[:filter.Limit]
+		history.NextCursor = strconv.FormatInt(history.Items[filter.Limit-1].ID, 10)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,257 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const accountExpired = "system:expired"
+
+type accrualLot struct {
+	id         int64
+	order      string
+	remaining  models.Money
+	creditedAt time.Time
+}
+
+func addLot(ctx context.Context, tx pgx.Tx, user, order string, amount models.Money) error {
+	return addLotAt(ctx, tx, user, order, amount, time.Now())
+}
+
+func addLotAt(ctx context.Context, tx pgx.Tx, user, order string, amount models.Money, creditedAt time.Time) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `INSERT INTO accrual_lots (username, order_number, amount, remaining, credited_at)
+				VALUES ($1, NULLIF($2, ''), $3, $3, $4)`, user, order, amount, creditedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add accrual lot: %w", err)
+	}
+
+	return nil
+}
+
+func lockLots(ctx context.Context, tx pgx.Tx, user string, before *time.Time) ([]accrualLot, error) {
+	var lots []accrualLot
+
+	query := `SELECT id, COALESCE(order_number, ''), remaining, credited_at FROM accrual_lots
+				WHERE username = $1 AND remaining > 0 AND ($2::TIMESTAMPTZ IS NULL OR credited_at < $2)
+				ORDER BY credited_at, id
+				FOR UPDATE`
+	rows, err := tx.Query(ctx, query, user, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock accrual lots: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lot accrualLot
+
+		if err := rows.Scan(&lot.id, &lot.order, &lot.remaining, &lot.creditedAt); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+
+	return lots, rows.Err()
+}
+
+// consumeLots returns the consumed parts, so a transfer can move them with their original dates.
+func consumeLots(ctx context.Context, tx pgx.Tx, user string, amount models.Money) ([]accrualLot, error) {
+	var consumed []accrualLot
+
+	lots, err := lockLots(ctx, tx, user, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	left := amount
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+
+		take := min(lot.remaining, left)
+		_, err := tx.Exec(ctx, `UPDATE accrual_lots SET remaining = remaining - $1 WHERE id = $2`, take, lot.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to consume accrual lot: %w", err)
+		}
+		left -= take
+
+		lot.remaining = take
+		consumed = append(consumed, lot)
+	}
+
+	if left > 0 {
+		return nil, fmt.Errorf("accrual lots of %s do not cover %s", user, amount)
+	}
+
+	return consumed, nil
+}
+
+// recordWithdrawalLots keeps the lots a withdrawal consumed, so a reversal can restore them with their original dates.
+func recordWithdrawalLots(ctx context.Context, tx pgx.Tx, orderNum string, lots []accrualLot) error {
+	for _, lot := range lots {
+		_, err := tx.Exec(ctx, `INSERT INTO withdrawal_lots (order_number, lot_order, amount, credited_at)
+					VALUES ($1, NULLIF($2, ''), $3, $4)`, orderNum, lot.order, lot.remaining, lot.creditedAt)
+		if err != nil {
+			return fmt.Errorf("failed to record withdrawal lot: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// restoreWithdrawalLots gives back the most recently credited parts first. Withdrawals made before
+// lots were recorded have nothing to restore, the rest of the amount becomes a new lot.
+func restoreWithdrawalLots(ctx context.Context, tx pgx.Tx, user, orderNum string, amount models.Money) error {
+	query := `SELECT id, COALESCE(lot_order, ''), amount - restored, credited_at FROM withdrawal_lots
+				WHERE order_number = $1 AND restored < amount
+				ORDER BY credited_at DESC, id DESC
+				FOR UPDATE`
+	rows, err := tx.Query(ctx, query, orderNum)
+	if err != nil {
+		return fmt.Errorf("failed to lock withdrawal lots: %w", err)
+	}
+	lots, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (accrualLot, error) {
+		var lot accrualLot
+		err := row.Scan(&lot.id, &lot.order, &lot.remaining, &lot.creditedAt)
+		return lot, err
+	})
+	if err != nil {
+		return err
+	}
+
+	left := amount
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+
+		take := min(lot.remaining, left)
+		_, err := tx.Exec(ctx, `UPDATE withdrawal_lots SET restored = restored + $1 WHERE id = $2`, take, lot.id)
+		if err != nil {
+			return fmt.Errorf("failed to restore withdrawal lot: %w", err)
+		}
+		if err := addLotAt(ctx, tx, user, lot.order, take, lot.creditedAt); err != nil {
+			return err
+		}
+		left -= take
+	}
+
+	return addLot(ctx, tx, user, orderNum, left)
+}
+
+func (p *PGDB) ExpirePoints(ctx context.Context, ttl time.Duration) (models.Money, error) {
+	var total models.Money
+
+	if ttl <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-ttl)
+
+	rows, err := p.db.Query(ctx, `SELECT DISTINCT username FROM accrual_lots WHERE remaining > 0 AND credited_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+
+	for _, user := range users {
+		expired, err := p.expireUserLots(ctx, user, cutoff)
+		if err != nil {
+			return total, fmt.Errorf("failed to expire points of %s: %w", user, err)
+		}
+		total += expired
+	}
+
+	return total, nil
+}
+
+func (p *PGDB) expireUserLots(ctx context.Context, user string, cutoff time.Time) (models.Money, error) {
+	var total models.Money
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock user balance: %w", err)
+	}
+
+	lots, err := lockLots(ctx, tx, user, &cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	// Active holds are captured from the oldest lots, so those parts stay until the hold is resolved.
+	held, err := heldAmount(ctx, tx, user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get held amount: %w", err)
+	}
+
+	for _, lot := range lots {
+		kept := min(lot.remaining, held)
+		held -= kept
+		expired := lot.remaining - kept
+		if expired == 0 {
+			continue
+		}
+
+		_, err := tx.Exec(ctx, `UPDATE accrual_lots SET remaining = remaining - $1 WHERE id = $2`, expired, lot.id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to expire accrual lot: %w", err)
+		}
+
+		err = postLedger(ctx, tx, models.LedgerExpiry, lot.order, userLeg(user, -expired), systemLeg(accountExpired, expired))
+		if err != nil {
+			return 0, err
+		}
+		total += expired
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET accrual = accrual - $1 WHERE username = $2`, total, user)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update user balance: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return total, nil
+}
+
+func (p *PGDB) ExpiringPoints(ctx context.Context, user string, ttl, window time.Duration) (models.Money, error) {
+	var amount models.Money
+
+	if ttl <= 0 {
+		return 0, nil
+	}
+
+	before := time.Now().Add(window - ttl)
+	query := `SELECT COALESCE(SUM(remaining), 0) FROM accrual_lots
+				WHERE username = $1 AND remaining > 0 AND credited_at < $2`
+	err := p.db.QueryRow(ctx, query, user, before).Scan(&amount)
+	if err != nil {
+		return 0, err
+	}
+
+	// Expiring lots are the oldest ones, which active holds keep from expiring, as in expireUserLots.
+	held, err := heldAmount(ctx, p.db, user)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(amount-held, 0), nil
+}
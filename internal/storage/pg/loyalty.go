@@ -0,0 +1,78 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sinfirst/Ref-System/internal/config"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const accountBonus = "system:bonus"
+
+// Lifetime accrual counts only base accruals, so bonuses never push a user into a higher tier.
+const lifetimeAccrualQuery = `SELECT COALESCE(SUM(amount), 0) FROM ledger_entries
+				WHERE account = 'user:' || u.username AND kind = 'accrual'`
+
+func (p *PGDB) GetUserProfile(ctx context.Context, user string) (models.UserProfile, error) {
+	profile := models.UserProfile{}
+
+	query := `SELECT u.username, u.tier, u.tier_multiplier, (` + lifetimeAccrualQuery + `), u.tier_updated_at
+				FROM users u WHERE u.username = $1`
+	err := p.db.QueryRow(ctx, query, user).Scan(&profile.Login, &profile.Tier, &profile.Multiplier,
+		&profile.LifetimeAccrual, &profile.TierUpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return profile, models.ErrNotFound
+	}
+	if err != nil {
+		return profile, err
+	}
+
+	return profile, nil
+}
+
+func (p *PGDB) RecalculateTiers(ctx context.Context, tiers []config.LoyaltyTier) ([]models.TierChange, error) {
+	names := make([]string, 0, len(tiers))
+	mins := make([]string, 0, len(tiers))
+	multipliers := make([]string, 0, len(tiers))
+	for _, tier := range tiers {
+		names = append(names, tier.Name)
+		mins = append(mins, tier.MinAccrual.String())
+		multipliers = append(multipliers, tier.Multiplier.String())
+	}
+
+	query := `WITH tiers AS (
+					SELECT * FROM unnest($1::TEXT[], $2::NUMERIC[], $3::NUMERIC[]) AS t(name, min_accrual, multiplier)
+				), target AS (
+					SELECT u.username, u.tier AS old_tier,
+						COALESCE(t.name, '') AS tier, COALESCE(t.multiplier, 1) AS multiplier
+					FROM users u
+					LEFT JOIN LATERAL (
+						SELECT name, multiplier FROM tiers
+						WHERE min_accrual <= (` + lifetimeAccrualQuery + `)
+						ORDER BY min_accrual DESC LIMIT 1
+					) t ON true
+				)
+				UPDATE users u SET tier = target.tier, tier_multiplier = target.multiplier, tier_updated_at = now()
+				FROM target
+				WHERE u.username = target.username
+					AND (u.tier <> target.tier OR u.tier_multiplier <> target.multiplier)
+				RETURNING u.username, target.old_tier, target.tier`
+	rows, err := p.db.Query(ctx, query, names, mins, multipliers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recalculate tiers: %w", err)
+	}
+
+	changes, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.TierChange, error) {
+		var c models.TierChange
+		err := row.Scan(&c.User, &c.From, &c.To)
+		return c, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to recalculate tiers: %w", err)
+	}
+
+	return changes, nil
+}
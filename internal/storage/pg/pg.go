@@ -3,9 +3,12 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose"
@@ -14,9 +17,13 @@ import (
 	"github.com/sinfirst/Ref-System/internal/models"
 )
 
+const AccrualJobsChannel = "accrual_jobs"
+
 type PGDB struct {
-	logger *logging.Logger
-	db     *pgxpool.Pool
+	logger   *logging.Logger
+	db       *pgxpool.Pool
+	lockMu   sync.Mutex
+	lockConn *pgx.Conn
 }
 
 func NewPGDB(conf config.Config, logger *logging.Logger) *PGDB {
@@ -34,7 +41,10 @@ func NewPGDB(conf config.Config, logger *logging.Logger) *PGDB {
 		return nil
 	}
 
-	return &PGDB{logger: logger, db: db}
+	return &PGDB{
+		logger: logger,
+		db:     db,
+	}
 }
 
 func (p *PGDB) CheckUsernameExists(ctx context.Context, username string) (bool, error) {
@@ -87,6 +97,9 @@ func (p *PGDB) GetOrderAndUser(ctx context.Context, order string) (string, strin
 	query := `SELECT number, username FROM orders WHERE number = $1`
 	row := p.db.QueryRow(ctx, query, order)
 	err := row.Scan(&userORder, &userName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", models.ErrNotFound
+	}
 	if err != nil {
 		return "", "", err
 	}
@@ -94,20 +107,43 @@ func (p *PGDB) GetOrderAndUser(ctx context.Context, order string) (string, strin
 }
 
 func (p *PGDB) AddOrderToDB(ctx context.Context, order string, username string) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
 	query := `INSERT INTO orders (number, uploaded_at, username)
 				VALUES ($1, $2, $3) ON CONFLICT (number) DO NOTHING`
-	_, err := p.db.Exec(ctx, query, order, time.Now(), username)
-
+	res, err := tx.Exec(ctx, query, order, time.Now(), username)
 	if err != nil {
 		return err
 	}
 
+	if res.RowsAffected() != 0 {
+		query = `INSERT INTO accrual_jobs (order_number, username)
+				VALUES ($1, $2) ON CONFLICT (order_number) DO NOTHING`
+		_, err = tx.Exec(ctx, query, order, username)
+		if err != nil {
+			return fmt.Errorf("failed to enqueue accrual job: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, AccrualJobsChannel, order)
+		if err != nil {
+			return fmt.Errorf("failed to notify accrual job: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return nil
 }
 
 func (p *PGDB) UpdateStatus(ctx context.Context, newStatus, order, user string) error {
 	query := `UPDATE orders SET status = $1
-			WHERE number = $2`
+			WHERE number = $2 AND status NOT IN ('PROCESSED', 'INVALID')`
 	result, err := p.db.Exec(ctx, query, newStatus, order)
 
 	if err != nil {
@@ -115,61 +151,421 @@ func (p *PGDB) UpdateStatus(ctx context.Context, newStatus, order, user string)
 	}
 
 	if result.RowsAffected() == 0 {
-		return fmt.Errorf("0 rows affected")
+		var exists bool
+		err = p.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE number = $1)`, order).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("checking order existence: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("0 rows affected")
+		}
 	}
 
 	return nil
 }
 
-func (p *PGDB) UpdateUserBalance(ctx context.Context, user string, accrual, withdrawn float64) error {
-	query := `UPDATE users SET accrual = $1, withdrawn = $2
-			WHERE username = $3`
-	result, err := p.db.Exec(ctx, query, int(accrual*100), int(withdrawn*100), user)
+func (p *PGDB) UpdateOrderProgress(ctx context.Context, order string, accrual models.Money) (bool, error) {
+	var user string
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE orders SET status = 'PROCESSED', accrual = $1
+				WHERE number = $2 AND status NOT IN ('PROCESSED', 'INVALID')
+				RETURNING COALESCE(username, '')`
+	err = tx.QueryRow(ctx, query, accrual, order).Scan(&user)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE number = $1)`, order).Scan(&exists)
+		if err != nil {
+			return false, fmt.Errorf("checking order existence: %w", err)
+		}
+		if !exists {
+			return false, fmt.Errorf("order not found")
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	var multiplier models.Money
+	err = tx.QueryRow(ctx, `SELECT tier_multiplier FROM users WHERE username = $1 FOR UPDATE`, user).Scan(&multiplier)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get user tier: %w", err)
+	}
+	// Tiers never lower an accrual, a multiplier below 1 left from an older config gives no bonus.
+	bonus := max(accrual.Mul(multiplier)-accrual, 0)
+
+	_, err = tx.Exec(ctx, "UPDATE users SET accrual = accrual + $1 WHERE username = $2", accrual+bonus, user)
+	if err != nil {
+		return false, fmt.Errorf("failed to update user balance: %w", err)
+	}
+
+	err = postLedger(ctx, tx, models.LedgerAccrual, order, userLeg(user, accrual), systemLeg(accountAccrual, -accrual))
+	if err != nil {
+		return false, err
+	}
+
+	if bonus > 0 {
+		err = postLedger(ctx, tx, models.LedgerBonus, order, userLeg(user, bonus), systemLeg(accountBonus, -bonus))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := addLot(ctx, tx, user, order, accrual+bonus); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+func (p *PGDB) RecoverAccrualJobs(ctx context.Context) (int64, error) {
+	query := `INSERT INTO accrual_jobs (order_number, username)
+				SELECT number, username FROM orders
+				WHERE status IN ('NEW', 'PROCESSING')
+					AND number NOT IN (SELECT order_number FROM accrual_dead_letters)
+				ON CONFLICT (order_number) DO NOTHING`
+	res, err := p.db.Exec(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover accrual jobs: %w", err)
+	}
+
+	if res.RowsAffected() != 0 {
+		_, err = p.db.Exec(ctx, `SELECT pg_notify($1, '')`, AccrualJobsChannel)
+		if err != nil {
+			return 0, fmt.Errorf("failed to notify accrual jobs: %w", err)
+		}
+	}
+
+	return res.RowsAffected(), nil
+}
+
+func (p *PGDB) NextAccrualJobAt(ctx context.Context) (time.Time, bool, error) {
+	var next *time.Time
+	query := `SELECT min(GREATEST(next_poll_at, COALESCE(locked_until, next_poll_at))) FROM accrual_jobs`
+	err := p.db.QueryRow(ctx, query).Scan(&next)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if next == nil {
+		return time.Time{}, false, nil
+	}
+	return *next, true, nil
+}
+
+func (p *PGDB) ListenAccrualJobs(ctx context.Context, ready func(), notify func(order string)) error {
+	conn, err := pgx.ConnectConfig(ctx, p.db.Config().ConnConfig.Copy())
+	if err != nil {
+		return fmt.Errorf("failed to connect listener: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+AccrualJobsChannel)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	ready()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		notify(notification.Payload)
+	}
+}
+
+func (p *PGDB) ClaimAccrualJobs(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	var jobs []models.AccrualJob
+	query := `UPDATE accrual_jobs SET locked_by = $3, locked_until = now() + make_interval(secs => $2)
+				WHERE order_number IN (
+					SELECT order_number FROM accrual_jobs
+					WHERE next_poll_at <= now() AND (locked_until IS NULL OR locked_until < now())
+					ORDER BY next_poll_at
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING order_number, COALESCE(username, ''), attempts, next_poll_at, COALESCE(last_error, '')`
+	rows, err := p.db.Query(ctx, query, limit, lease.Seconds(), owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim accrual jobs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var j models.AccrualJob
+
+		err := rows.Scan(&j.OrderNum, &j.User, &j.Attempts, &j.NextPollAt, &j.LastError)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
 
+func (p *PGDB) RescheduleAccrualJob(ctx context.Context, owner, order string, delay time.Duration, lastError string) error {
+	query := `WITH job AS (
+					UPDATE accrual_jobs
+					SET attempts = attempts + 1, next_poll_at = now() + make_interval(secs => $2), last_error = $3,
+						locked_by = NULL, locked_until = NULL
+					WHERE order_number = $1 AND locked_by = $4
+					RETURNING order_number, attempts
+				)
+				INSERT INTO accrual_job_attempts (order_number, attempt, error)
+				SELECT order_number, attempts, $3 FROM job`
+	res, err := p.db.Exec(ctx, query, order, delay.Seconds(), lastError, owner)
 	if err != nil {
 		return err
 	}
+	if res.RowsAffected() == 0 {
+		return models.ErrLeaseLost
+	}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("0 rows affected")
+	return nil
+}
+
+func (p *PGDB) RenewAccrualJobLease(ctx context.Context, owner, order string, lease time.Duration) error {
+	query := `UPDATE accrual_jobs SET locked_until = now() + make_interval(secs => $3)
+				WHERE order_number = $1 AND locked_by = $2`
+	res, err := p.db.Exec(ctx, query, order, owner, lease.Seconds())
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return models.ErrLeaseLost
 	}
 
 	return nil
 }
 
-func (p *PGDB) UpdateOrderProgress(ctx context.Context, newStatus, order, user string, accrual, withdrawn float64) error {
-	tx, err := p.db.Begin(ctx)
+func (p *PGDB) DeadLetterAccrualJob(ctx context.Context, owner, order string, lastError string) error {
+	query := `WITH job AS (
+					DELETE FROM accrual_jobs WHERE order_number = $1 AND locked_by = $3
+					RETURNING order_number, username, attempts + 1 AS attempts
+				), attempt AS (
+					INSERT INTO accrual_job_attempts (order_number, attempt, error)
+					SELECT order_number, attempts, $2 FROM job
+				)
+				INSERT INTO accrual_dead_letters (order_number, username, attempts, last_error)
+				SELECT order_number, username, attempts, $2 FROM job
+				ON CONFLICT (order_number) DO UPDATE
+				SET attempts = EXCLUDED.attempts, last_error = EXCLUDED.last_error, dead_at = now()`
+	res, err := p.db.Exec(ctx, query, order, lastError, owner)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return err
 	}
-	defer tx.Rollback(ctx)
+	if res.RowsAffected() == 0 {
+		return models.ErrLeaseLost
+	}
+
+	return nil
+}
+
+func (p *PGDB) GetDeadLetters(ctx context.Context, after *models.DeadLetterCursor, limit int) (models.DeadLetterPage, error) {
+	page := models.DeadLetterPage{}
+
+	var afterAt *time.Time
+	var afterOrder string
+	if after != nil {
+		afterAt, afterOrder = &after.DeadAt, after.OrderNum
+	}
+
+	query := `WITH page AS (
+					SELECT order_number, username, attempts, last_error, dead_at FROM accrual_dead_letters
+					WHERE $1::TIMESTAMPTZ IS NULL OR (dead_at, order_number) > ($1, $2)
+					ORDER BY dead_at, order_number
+					LIMIT $3
+				)
+				SELECT d.order_number, COALESCE(d.username, ''), d.attempts, COALESCE(d.last_error, ''), d.dead_at,
+					a.attempt, a.error, a.attempted_at
+				FROM page d
+				LEFT JOIN accrual_job_attempts a ON a.order_number = d.order_number
+				ORDER BY d.dead_at, d.order_number, a.id`
+	rows, err := p.db.Query(ctx, query, afterAt, afterOrder, limit+1)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	deadLetters := page.Items
+	for rows.Next() {
+		var d models.DeadLetter
+		var attempt *int
+		var attemptErr *string
+		var attemptedAt *time.Time
+
+		err := rows.Scan(&d.OrderNum, &d.User, &d.Attempts, &d.LastError, &d.DeadAt, &attempt, &attemptErr, &attemptedAt)
+		if err != nil {
+			return page, err
+		}
+
+		last := len(deadLetters) - 1
+		if last < 0 || deadLetters[last].OrderNum != d.OrderNum {
+			deadLetters = append(deadLetters, d)
+			last++
+		}
+
+		if attempt != nil {
+			a := models.AccrualAttempt{Attempt: *attempt, AttemptedAt: *attemptedAt}
+			if attemptErr != nil {
+				a.Error = *attemptErr
+			}
+			deadLetters[last].History = append(deadLetters[last].History, a)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(deadLetters) > limit {
+		deadLetters = deadLetters[:limit]
+		last := deadLetters[limit-1]
+		page.NextCursor = models.DeadLetterCursor{DeadAt: last.DeadAt, OrderNum: last.OrderNum}.String()
+	}
+	page.Items = deadLetters
+
+	return page, nil
+}
 
-	res, err := tx.Exec(ctx, "UPDATE orders SET status = $1 WHERE number = $2", newStatus, order)
+// RequeueDeadLetter starts the job over, its attempts count from 1 again, so the old attempts are cleared.
+func (p *PGDB) RequeueDeadLetter(ctx context.Context, order string) error {
+	query := `WITH dead AS (
+					DELETE FROM accrual_dead_letters WHERE order_number = $1
+					RETURNING order_number, username
+				), cleared AS (
+					DELETE FROM accrual_job_attempts a USING dead WHERE a.order_number = dead.order_number
+				)
+				INSERT INTO accrual_jobs (order_number, username)
+				SELECT order_number, username FROM dead
+				ON CONFLICT (order_number) DO UPDATE
+				SET next_poll_at = now(), attempts = 0, locked_by = NULL, locked_until = NULL`
+	res, err := p.db.Exec(ctx, query, order)
 	if err != nil {
-		return fmt.Errorf("failed to update order status: %w", err)
+		return err
 	}
+
 	if res.RowsAffected() == 0 {
-		return fmt.Errorf("order not found")
+		return models.ErrNotFound
 	}
 
-	res, err = tx.Exec(ctx, "UPDATE users SET accrual = accrual + $1, withdrawn = withdrawn + $2 WHERE username = $3",
-		int(accrual*100), int(withdrawn*100), user)
+	_, err = p.db.Exec(ctx, `SELECT pg_notify($1, $2)`, AccrualJobsChannel, order)
+	return err
+}
+
+func (p *PGDB) DeferAccrualJob(ctx context.Context, owner, order string, delay time.Duration) error {
+	query := `UPDATE accrual_jobs
+				SET next_poll_at = now() + make_interval(secs => $2), locked_by = NULL, locked_until = NULL
+				WHERE order_number = $1 AND locked_by = $3`
+	res, err := p.db.Exec(ctx, query, order, delay.Seconds(), owner)
 	if err != nil {
-		return fmt.Errorf("failed to update user balance: %w", err)
+		return err
 	}
 	if res.RowsAffected() == 0 {
-		return fmt.Errorf("user not found")
+		return models.ErrLeaseLost
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	return nil
+}
+
+// CompleteAccrualJob with an empty owner removes the job whoever holds it, pushed final statuses use that.
+func (p *PGDB) CompleteAccrualJob(ctx context.Context, owner, order string) error {
+	query := `DELETE FROM accrual_jobs WHERE order_number = $1 AND ($2 = '' OR locked_by = $2)`
+	res, err := p.db.Exec(ctx, query, order, owner)
+	if err != nil {
+		return err
+	}
+	if owner != "" && res.RowsAffected() == 0 {
+		return models.ErrLeaseLost
 	}
 
 	return nil
 }
+
+// TryAdvisoryLock keeps all session locks on one connection outside the pool, so a job holding
+// its lock never pins a pooled connection while it runs. Each key has a single holder per process,
+// the locks are reentrant within the session.
+func (p *PGDB) TryAdvisoryLock(ctx context.Context, key int64) (func(), bool, error) {
+	p.lockMu.Lock()
+	defer p.lockMu.Unlock()
+
+	if p.lockConn == nil || p.lockConn.IsClosed() {
+		conn, err := pgx.ConnectConfig(ctx, p.db.Config().ConnConfig)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to open lock connection: %w", err)
+		}
+		p.lockConn = conn
+	}
+	conn := p.lockConn
+
+	var locked bool
+	err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked)
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !locked {
+		return nil, false, nil
+	}
+
+	release := func() {
+		p.lockMu.Lock()
+		defer p.lockMu.Unlock()
+
+		// A closed session has already dropped its locks.
+		if conn.IsClosed() {
+			return
+		}
+		_, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+		if err != nil {
+			p.logger.Logger.Errorw("Failed to release advisory lock", "key", key, "err", err)
+			conn.Close(context.Background())
+		}
+	}
+
+	return release, true, nil
+}
+
+func (p *PGDB) LastJobRun(ctx context.Context, name string) (time.Time, bool, error) {
+	var lastRun time.Time
+
+	err := p.db.QueryRow(ctx, `SELECT last_run_at FROM scheduled_job_runs WHERE name = $1`, name).Scan(&lastRun)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return lastRun, false, nil
+	}
+	if err != nil {
+		return lastRun, false, err
+	}
+
+	return lastRun, true, nil
+}
+
+func (p *PGDB) SaveJobRun(ctx context.Context, name string, at time.Time) error {
+	query := `INSERT INTO scheduled_job_runs (name, last_run_at) VALUES ($1, $2)
+				ON CONFLICT (name) DO UPDATE SET last_run_at = EXCLUDED.last_run_at`
+	_, err := p.db.Exec(ctx, query, name, at)
+
+	return err
+}
+
 func (p *PGDB) GetUserOrders(ctx context.Context, user string) ([]models.Order, error) {
 	var orders []models.Order
-	query := `SELECT number, status, uploaded_at 
+	query := `SELECT number, status, COALESCE(accrual, 0), uploaded_at 
 		FROM orders WHERE username = $1`
 	rows, err := p.db.Query(ctx, query, user)
 
@@ -181,7 +577,7 @@ func (p *PGDB) GetUserOrders(ctx context.Context, user string) ([]models.Order,
 	for rows.Next() {
 		var o models.Order
 
-		err := rows.Scan(&o.Number, &o.Status, &o.UploadAt)
+		err := rows.Scan(&o.Number, &o.Status, &o.Accrual, &o.UploadAt)
 		if err != nil {
 			return nil, err
 		}
@@ -192,37 +588,244 @@ func (p *PGDB) GetUserOrders(ctx context.Context, user string) ([]models.Order,
 	return orders, nil
 }
 
+func (p *PGDB) GetProcessedOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	var orders []models.Order
+	query := `SELECT number, status, COALESCE(accrual, 0), uploaded_at
+		FROM orders WHERE status = 'PROCESSED'
+		ORDER BY random()`
+	args := []any{}
+	if limit > 0 {
+		query += ` LIMIT $1`
+		args = append(args, limit)
+	}
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.Order
+
+		err := rows.Scan(&o.Number, &o.Status, &o.Accrual, &o.UploadAt)
+		if err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, o)
+	}
+
+	return orders, rows.Err()
+}
+
+func (p *PGDB) SaveAccrualDiscrepancy(ctx context.Context, d models.AccrualDiscrepancy) error {
+	query := `INSERT INTO accrual_discrepancies (order_number, username, stored_accrual, reported_status, reported_accrual)
+				SELECT number, username, $2, $3, $4 FROM orders WHERE number = $1
+				ON CONFLICT (order_number) DO UPDATE
+				SET stored_accrual = EXCLUDED.stored_accrual, reported_status = EXCLUDED.reported_status,
+					reported_accrual = EXCLUDED.reported_accrual, checked_at = now()`
+	_, err := p.db.Exec(ctx, query, d.OrderNum, d.StoredAccrual, d.ReportedStatus, d.ReportedAccrual)
+
+	return err
+}
+
+func (p *PGDB) ResolveAccrualDiscrepancy(ctx context.Context, order string) error {
+	query := `DELETE FROM accrual_discrepancies WHERE order_number = $1`
+	_, err := p.db.Exec(ctx, query, order)
+
+	return err
+}
+
+func (p *PGDB) GetAccrualDiscrepancies(ctx context.Context) ([]models.AccrualDiscrepancy, error) {
+	var discrepancies []models.AccrualDiscrepancy
+	query := `SELECT order_number, COALESCE(username, ''), stored_accrual, reported_status, reported_accrual,
+					detected_at, checked_at
+				FROM accrual_discrepancies ORDER BY detected_at`
+	rows, err := p.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.AccrualDiscrepancy
+
+		err := rows.Scan(&d.OrderNum, &d.User, &d.StoredAccrual, &d.ReportedStatus, &d.ReportedAccrual,
+			&d.DetectedAt, &d.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		discrepancies = append(discrepancies, d)
+	}
+
+	return discrepancies, rows.Err()
+}
+
 func (p *PGDB) GetUserBalance(ctx context.Context, user string) (models.UserBalance, error) {
-	var balance models.UserBalance
-	query := `SELECT accrual, withdrawn 
-		FROM users WHERE username = $1`
-	row := p.db.QueryRow(ctx, query, user)
-	err := row.Scan(&balance.Current, &balance.Withdrawn)
+	current, withdrawn, err := ledgerBalance(ctx, p.db, user)
+	if err != nil {
+		return models.UserBalance{}, err
+	}
 
+	held, err := heldAmount(ctx, p.db, user)
 	if err != nil {
 		return models.UserBalance{}, err
 	}
 
-	balance = models.UserBalance{
-		Current:   balance.Current / 100,
-		Withdrawn: balance.Withdrawn / 100,
+	balance := models.UserBalance{
+		Current:   current - held,
+		Withdrawn: withdrawn,
+		Held:      held,
 	}
 
 	return balance, nil
 }
 
-func (p *PGDB) SetUserWithdrawn(ctx context.Context, orderNum, user string, withdrawn float64) error {
-	query := `INSERT INTO withdrawals (orderNum, sum, precessed_at, username)
-				VALUES ($1, $2, $3, $4) ON CONFLICT (orderNum) DO NOTHING`
-	_, err := p.db.Exec(ctx, query, orderNum, withdrawn*100, time.Now(), user)
+func (p *PGDB) Withdraw(ctx context.Context, user, orderNum, merchant string, sum models.Money) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	return err
+	_, err = tx.Exec(ctx, `SELECT 1 FROM users WHERE username = $1 FOR UPDATE`, user)
+	if err != nil {
+		return fmt.Errorf("failed to lock user balance: %w", err)
+	}
+
+	available, err := availableBalance(ctx, tx, user)
+	if err != nil {
+		return fmt.Errorf("failed to get user balance: %w", err)
+	}
+
+	if available < sum {
+		return models.ErrInsufficientFunds
+	}
+
+	var held bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM withdrawal_holds WHERE order_number = $1 AND status = 'held')`,
+		orderNum).Scan(&held)
+	if err != nil {
+		return fmt.Errorf("failed to check withdrawal holds: %w", err)
+	}
+	if held {
+		return models.ErrWithdrawalExists
+	}
+
+	if err := postWithdrawal(ctx, tx, user, orderNum, merchant, sum); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func postWithdrawal(ctx context.Context, tx pgx.Tx, user, orderNum, merchant string, sum models.Money) error {
+	query := `INSERT INTO withdrawals (orderNum, sum, precessed_at, username, merchant)
+				VALUES ($1, $2, $3, $4, NULLIF($5, '')) ON CONFLICT (orderNum) DO NOTHING`
+	res, err := tx.Exec(ctx, query, orderNum, sum, time.Now(), user, merchant)
+	if err != nil {
+		return fmt.Errorf("failed to insert withdrawal: %w", err)
+	}
+	if res.RowsAffected() == 0 {
+		return models.ErrWithdrawalExists
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET accrual = accrual - $1, withdrawn = withdrawn + $1 WHERE username = $2`,
+		sum, user)
+	if err != nil {
+		return fmt.Errorf("failed to update user balance: %w", err)
+	}
+
+	err = postLedger(ctx, tx, models.LedgerWithdrawal, orderNum, userLeg(user, -sum), systemLeg(accountWithdrawals, sum))
+	if err != nil {
+		return err
+	}
+
+	lots, err := consumeLots(ctx, tx, user, sum)
+	if err != nil {
+		return err
+	}
+
+	return recordWithdrawalLots(ctx, tx, orderNum, lots)
+}
+
+// ReverseWithdrawal returns ErrNotFound unless the withdrawal was made to merchant, an empty merchant matches any withdrawal.
+func (p *PGDB) ReverseWithdrawal(ctx context.Context, orderNum, merchant string, amount models.Money, reason, actor string) (models.WithdrawalReversal, error) {
+	reversal := models.WithdrawalReversal{OrderNum: orderNum, Reason: reason, Actor: actor}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return reversal, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var user string
+	var sum, reversed models.Money
+	query := `SELECT COALESCE(w.username, ''), w.sum,
+					COALESCE((SELECT SUM(r.amount) FROM withdrawal_reversals r WHERE r.order_number = w.orderNum), 0)
+				FROM withdrawals w WHERE w.orderNum = $1 AND ($2 = '' OR w.merchant = $2) FOR UPDATE`
+	err = tx.QueryRow(ctx, query, orderNum, merchant).Scan(&user, &sum, &reversed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return reversal, models.ErrNotFound
+	}
+	if err != nil {
+		return reversal, fmt.Errorf("failed to lock withdrawal: %w", err)
+	}
+	if user == "" {
+		return reversal, models.ErrNotFound
+	}
+
+	remaining := sum - reversed
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return reversal, models.ErrReversalExceeds
+	}
+
+	query = `INSERT INTO withdrawal_reversals (order_number, username, amount, reason, actor)
+				VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err = tx.QueryRow(ctx, query, orderNum, user, amount, reason, actor).Scan(&reversal.ID, &reversal.CreatedAt)
+	if err != nil {
+		return reversal, fmt.Errorf("failed to insert reversal: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET accrual = accrual + $1, withdrawn = withdrawn - $1 WHERE username = $2`,
+		amount, user)
+	if err != nil {
+		return reversal, fmt.Errorf("failed to update user balance: %w", err)
+	}
+
+	err = postLedger(ctx, tx, models.LedgerReversal, orderNum, userLeg(user, amount), systemLeg(accountWithdrawals, -amount))
+	if err != nil {
+		return reversal, err
+	}
+
+	if err := restoreWithdrawalLots(ctx, tx, user, orderNum, amount); err != nil {
+		return reversal, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return reversal, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	reversal.Amount = amount
+	reversal.Remaining = remaining - amount
+	return reversal, nil
 }
 
 func (p *PGDB) GetUserWithdrawns(ctx context.Context, user string) ([]models.UserWithdrawal, error) {
 	var UserWithdrawals []models.UserWithdrawal
-	query := `SELECT orderNum, sum, precessed_at 
-				FROM withdrawals WHERE username = $1`
+	query := `SELECT w.orderNum, w.sum,
+					COALESCE((SELECT SUM(r.amount) FROM withdrawal_reversals r WHERE r.order_number = w.orderNum), 0),
+					w.precessed_at, COALESCE(w.merchant, '')
+				FROM withdrawals w WHERE w.username = $1`
 	rows, err := p.db.Query(ctx, query, user)
 
 	if err != nil {
@@ -233,16 +836,12 @@ func (p *PGDB) GetUserWithdrawns(ctx context.Context, user string) ([]models.Use
 	for rows.Next() {
 		var o models.UserWithdrawal
 
-		err := rows.Scan(&o.OrderNum, &o.Sum, &o.ProcessedAt)
+		err := rows.Scan(&o.OrderNum, &o.Sum, &o.Reversed, &o.ProcessedAt, &o.Merchant)
 		if err != nil {
 			return nil, err
 		}
 
-		UserWithdrawals = append(UserWithdrawals, models.UserWithdrawal{
-			Sum:         o.Sum / 100,
-			OrderNum:    o.OrderNum,
-			ProcessedAt: o.ProcessedAt,
-		})
+		UserWithdrawals = append(UserWithdrawals, o)
 	}
 
 	return UserWithdrawals, nil
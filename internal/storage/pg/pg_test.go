@@ -0,0 +1,621 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pressly/goose"
+	"github.com/sinfirst/Ref-System/internal/config"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+	"github.com/sinfirst/Ref-System/internal/scheduler"
+)
+
+// newTestDB needs a disposable database in TEST_DATABASE_URI, its data is wiped on every call.
+func newTestDB(t *testing.T) *PGDB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	sqlDB, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	if err := goose.Up(sqlDB, "../migrations"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlDB.Exec(`TRUNCATE users, orders, withdrawals, ledger_entries CASCADE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := NewPGDB(config.Config{DatabaseDsn: dsn}, logging.NewLogger())
+	if db == nil {
+		t.Fatal("failed to connect to test database")
+	}
+	t.Cleanup(func() {
+		db.db.Close()
+		if db.lockConn != nil {
+			db.lockConn.Close(context.Background())
+		}
+	})
+
+	return db
+}
+
+func addTestOrder(t *testing.T, db *PGDB, user, order string) {
+	t.Helper()
+	ctx := context.Background()
+
+	exists, err := db.CheckUsernameExists(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		if err := db.AddUserToDB(ctx, user, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.AddOrderToDB(ctx, order, user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var fundedOrders int
+
+// fundTestUser credits amount to user through a new processed order.
+func fundTestUser(t *testing.T, db *PGDB, user string, amount models.Money) {
+	t.Helper()
+
+	fundedOrders++
+	order := fmt.Sprintf("fund-%d", fundedOrders)
+	addTestOrder(t, db, user, order)
+	if _, err := db.UpdateOrderProgress(context.Background(), order, amount); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ledgerRows(t *testing.T, db *PGDB, order string) int {
+	t.Helper()
+
+	var n int
+	err := db.db.QueryRow(context.Background(), `SELECT count(*) FROM ledger_entries WHERE order_number = $1`, order).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func orderStatus(t *testing.T, db *PGDB, user, order string) string {
+	t.Helper()
+
+	orders, err := db.GetUserOrders(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range orders {
+		if o.Number == order {
+			return o.Status
+		}
+	}
+	t.Fatalf("order %s not found", order)
+	return ""
+}
+
+func TestUpdateOrderProgressIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	addTestOrder(t, db, "alice", "12345678903")
+
+	credited, err := db.UpdateOrderProgress(ctx, "12345678903", 72998)
+	if err != nil || !credited {
+		t.Fatalf("first credit: credited=%v err=%v", credited, err)
+	}
+	rows := ledgerRows(t, db, "12345678903")
+
+	credited, err = db.UpdateOrderProgress(ctx, "12345678903", 72998)
+	if err != nil || credited {
+		t.Fatalf("second credit: credited=%v err=%v", credited, err)
+	}
+
+	balance, err := db.GetUserBalance(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != 72998 {
+		t.Errorf("balance = %s, want 729.98", balance.Current)
+	}
+	if got := ledgerRows(t, db, "12345678903"); got != rows {
+		t.Errorf("ledger rows = %d after repeated credit, want %d", got, rows)
+	}
+}
+
+func TestUpdateStatusDoesNotDowngradeFinalOrders(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	addTestOrder(t, db, "alice", "12345678903")
+
+	if _, err := db.UpdateOrderProgress(ctx, "12345678903", 500); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateStatus(ctx, models.StatusProcessing, "12345678903", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := orderStatus(t, db, "alice", "12345678903"); got != models.StatusProcessed {
+		t.Errorf("status = %s, want %s", got, models.StatusProcessed)
+	}
+}
+
+func TestConcurrentWithdrawalsNeverOverdraw(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	const (
+		balance  = models.Money(100000)
+		sum      = models.Money(15000)
+		attempts = 20
+	)
+	fundTestUser(t, db, "alice", balance)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(order string) {
+			defer wg.Done()
+			errs <- db.Withdraw(ctx, "alice", order, "", sum)
+		}(fmt.Sprintf("2377225624%02d", i))
+	}
+	wg.Wait()
+	close(errs)
+
+	var succeeded int
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, models.ErrInsufficientFunds):
+			t.Errorf("unexpected withdraw error: %v", err)
+		}
+	}
+
+	if want := int(balance / sum); succeeded != want {
+		t.Errorf("succeeded = %d, want %d", succeeded, want)
+	}
+	current, err := db.GetUserBalance(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := balance - sum*models.Money(succeeded); current.Current != want {
+		t.Errorf("balance = %s, want %s", current.Current, want)
+	}
+}
+
+func TestMerchantReversesOnlyOwnWithdrawals(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+	if err := db.Withdraw(ctx, "alice", "2377225624", "shop", 3000); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.ReverseWithdrawal(ctx, "2377225624", "other", 0, "cancelled", "merchant:other")
+	if !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("foreign merchant reversal: err = %v, want ErrNotFound", err)
+	}
+
+	reversal, err := db.ReverseWithdrawal(ctx, "2377225624", "shop", 0, "cancelled", "merchant:shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reversal.Amount != 3000 {
+		t.Errorf("reversed = %s, want 30", reversal.Amount)
+	}
+}
+
+func TestReversalRestoresOriginalLotDates(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+	_, err := db.db.Exec(ctx, `UPDATE accrual_lots SET credited_at = now() - interval '10 days' WHERE username = 'alice'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Withdraw(ctx, "alice", "2377225624", "", 4000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ReverseWithdrawal(ctx, "2377225624", "", 0, "cancelled", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := db.ExpirePoints(ctx, 5*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 10000 {
+		t.Errorf("expired = %s, want 100: reversed points must keep their credit date", expired)
+	}
+}
+
+func TestMerchantResolvesOnlyOwnHolds(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+	if _, err := db.HoldWithdrawal(ctx, "alice", "2377225624", "shop", 3000, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CaptureHold(ctx, "", "other", "2377225624"); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("foreign merchant capture: err = %v, want ErrNotFound", err)
+	}
+	if _, err := db.ReleaseHold(ctx, "", "other", "2377225624"); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("foreign merchant release: err = %v, want ErrNotFound", err)
+	}
+	if _, err := db.ReleaseHold(ctx, "alice", "", "2377225624"); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("user release of a merchant hold: err = %v, want ErrNotFound", err)
+	}
+
+	hold, err := db.CaptureHold(ctx, "", "shop", "2377225624")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hold.Status != models.HoldCaptured || hold.Merchant != "shop" {
+		t.Errorf("hold = %+v, want captured by shop", hold)
+	}
+}
+
+func TestReleasedHoldCanBeHeldAgain(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+	if _, err := db.HoldWithdrawal(ctx, "alice", "2377225624", "", 3000, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HoldWithdrawal(ctx, "alice", "2377225624", "", 3000, time.Hour); !errors.Is(err, models.ErrWithdrawalExists) {
+		t.Fatalf("second active hold: err = %v, want ErrWithdrawalExists", err)
+	}
+	if _, err := db.ReleaseHold(ctx, "alice", "", "2377225624"); err != nil {
+		t.Fatal(err)
+	}
+
+	hold, err := db.HoldWithdrawal(ctx, "alice", "2377225624", "", 2000, time.Hour)
+	if err != nil {
+		t.Fatalf("hold after release: %v", err)
+	}
+	if hold.Sum != 2000 || hold.Status != models.HoldHeld {
+		t.Errorf("hold = %+v, want 20 held", hold)
+	}
+}
+
+func TestStaleIdempotencyOwnerCannotComplete(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	addTestOrder(t, db, "alice", "12345678903")
+
+	first, acquired, err := db.BeginIdempotencyKey(ctx, "alice", "k1", "hash", time.Now())
+	if err != nil || !acquired {
+		t.Fatalf("first begin: acquired=%v err=%v", acquired, err)
+	}
+	second, acquired, err := db.BeginIdempotencyKey(ctx, "alice", "k1", "hash", time.Now().Add(time.Minute))
+	if err != nil || !acquired {
+		t.Fatalf("stale takeover: acquired=%v err=%v", acquired, err)
+	}
+
+	err = db.CompleteIdempotencyKey(ctx, "alice", "k1", first.LockedAt, 200, "", nil)
+	if !errors.Is(err, models.ErrIdempotencyLost) {
+		t.Fatalf("stale complete: err = %v, want ErrIdempotencyLost", err)
+	}
+	if err := db.ReleaseIdempotencyKey(ctx, "alice", "k1", first.LockedAt); !errors.Is(err, models.ErrIdempotencyLost) {
+		t.Fatalf("stale release: err = %v, want ErrIdempotencyLost", err)
+	}
+	if err := db.CompleteIdempotencyKey(ctx, "alice", "k1", second.LockedAt, 200, "", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequeuedDeadLetterStartsAttemptsOver(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	addTestOrder(t, db, "alice", "12345678903")
+
+	claim := func() {
+		t.Helper()
+		jobs, err := db.ClaimAccrualJobs(ctx, "w1", 10, time.Minute)
+		if err != nil || len(jobs) != 1 {
+			t.Fatalf("claim: jobs=%d err=%v", len(jobs), err)
+		}
+	}
+
+	claim()
+	if err := db.RescheduleAccrualJob(ctx, "w1", "12345678903", 0, "first"); err != nil {
+		t.Fatal(err)
+	}
+	claim()
+	if err := db.DeadLetterAccrualJob(ctx, "w1", "12345678903", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RequeueDeadLetter(ctx, "12345678903"); err != nil {
+		t.Fatal(err)
+	}
+	claim()
+	if err := db.DeadLetterAccrualJob(ctx, "w1", "12345678903", "again"); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := db.GetDeadLetters(ctx, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 {
+		t.Fatalf("dead letters = %d, want 1", len(page.Items))
+	}
+	history := page.Items[0].History
+	if len(history) != 1 || history[0].Attempt != 1 {
+		t.Errorf("history = %+v, want a single attempt 1", history)
+	}
+}
+
+func TestSchedulerRunsMoreJobsThanPoolConnections(t *testing.T) {
+	db := newTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := scheduler.New(db, logging.NewLogger())
+	n := int(db.db.Config().MaxConns) + 2
+	done := make(chan string, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("test job %d", i)
+		jobs.Start(ctx, scheduler.Job{
+			Name:       name,
+			LockKey:    int64(9000 + i),
+			Interval:   time.Hour,
+			RunAtStart: true,
+			Run: func(ctx context.Context) error {
+				_, err := db.db.Exec(ctx, `SELECT pg_sleep(0.1)`)
+				if err == nil {
+					done <- name
+				}
+				return err
+			},
+		})
+	}
+
+	timeout := time.After(10 * time.Second)
+	for i := 0; i < n; i++ {
+		select {
+		case <-done:
+		case <-timeout:
+			t.Fatalf("only %d of %d jobs ran, the pool is exhausted", i, n)
+		}
+	}
+	cancel()
+	jobs.Wait()
+}
+
+func TestHeldPointsAreNotExpiring(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+
+	_, err := db.db.Exec(ctx, `UPDATE accrual_lots SET credited_at = now() - interval '10 days' WHERE username = 'alice'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HoldWithdrawal(ctx, "alice", "2377225624", "", 3000, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	expiring, err := db.ExpiringPoints(ctx, "alice", 12*24*time.Hour, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiring != 7000 {
+		t.Errorf("expiring soon = %s, want 70", expiring)
+	}
+
+	expired, err := db.ExpirePoints(ctx, 5*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != expiring {
+		t.Errorf("expired = %s, want the reported %s", expired, expiring)
+	}
+}
+
+func addTestUser(t *testing.T, db *PGDB, user string) {
+	t.Helper()
+
+	if err := db.AddUserToDB(context.Background(), user, "secret"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferLimits(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+	addTestUser(t, db, "bob")
+
+	tests := []struct {
+		name       string
+		from, to   string
+		sum        models.Money
+		maxAmount  models.Money
+		dailyLimit models.Money
+		want       error
+	}{
+		{name: "over per-transfer cap", from: "alice", to: "bob", sum: 2000, maxAmount: 1000, want: models.ErrTransferLimit},
+		{name: "insufficient funds", from: "alice", to: "bob", sum: 20000, want: models.ErrInsufficientFunds},
+		{name: "missing recipient", from: "alice", to: "carol", sum: 1000, want: models.ErrNotFound},
+		{name: "self transfer", from: "alice", to: "alice", sum: 1000, want: models.ErrNotFound},
+		{name: "within daily limit", from: "alice", to: "bob", sum: 3000, dailyLimit: 5000},
+		{name: "over daily limit", from: "alice", to: "bob", sum: 3000, dailyLimit: 5000, want: models.ErrTransferLimit},
+	}
+
+	for _, tt := range tests {
+		err := db.Transfer(ctx, tt.from, tt.to, tt.sum, tt.maxAmount, tt.dailyLimit)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	alice, err := db.GetUserBalance(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Current != 7000 {
+		t.Errorf("alice balance = %s, want 70: only one transfer may go through", alice.Current)
+	}
+}
+
+func TestTransferPostsBothLegsAndKeepsLotDates(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+	addTestUser(t, db, "bob")
+
+	_, err := db.db.Exec(ctx, `UPDATE accrual_lots SET credited_at = now() - interval '10 days' WHERE username = 'alice'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Transfer(ctx, "alice", "bob", 4000, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.db.Query(ctx, `SELECT account, amount FROM ledger_entries WHERE kind = 'transfer' ORDER BY amount`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type leg struct {
+		account string
+		amount  models.Money
+	}
+	legs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (leg, error) {
+		var l leg
+		err := row.Scan(&l.account, &l.amount)
+		return l, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []leg{{account: "user:alice", amount: -4000}, {account: "user:bob", amount: 4000}}
+	if len(legs) != len(want) || legs[0] != want[0] || legs[1] != want[1] {
+		t.Errorf("ledger legs = %+v, want %+v", legs, want)
+	}
+
+	var moved models.Money
+	var order string
+	err = db.db.QueryRow(ctx, `SELECT COALESCE(SUM(remaining), 0), COALESCE(MAX(order_number), '') FROM accrual_lots
+				WHERE username = 'bob' AND credited_at < now() - interval '9 days'`).Scan(&moved, &order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 4000 || order != "" {
+		t.Errorf("bob's lots = %s from order %q, want 40 with the original date and no order", moved, order)
+	}
+}
+
+func userTier(t *testing.T, db *PGDB, user string) models.UserProfile {
+	t.Helper()
+
+	profile, err := db.GetUserProfile(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profile
+}
+
+func TestBonusIsPostedOnceAndNotCountedAsLifetime(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	addTestOrder(t, db, "alice", "12345678903")
+
+	tiers := []config.LoyaltyTier{{Name: "gold", MinAccrual: 0, Multiplier: 150}}
+	if _, err := db.RecalculateTiers(ctx, tiers); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.UpdateOrderProgress(ctx, "12345678903", 10000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bonuses int
+	var bonus models.Money
+	err := db.db.QueryRow(ctx, `SELECT count(*), COALESCE(SUM(amount), 0) FROM ledger_entries
+				WHERE kind = 'bonus' AND account = 'user:alice' AND order_number = $1`, "12345678903").Scan(&bonuses, &bonus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bonuses != 1 || bonus != 5000 {
+		t.Errorf("bonus entries = %d for %s, want one of 50", bonuses, bonus)
+	}
+
+	balance, err := db.GetUserBalance(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != 15000 {
+		t.Errorf("balance = %s, want 150", balance.Current)
+	}
+	if lifetime := userTier(t, db, "alice").LifetimeAccrual; lifetime != 10000 {
+		t.Errorf("lifetime accrual = %s, want 100 without the bonus", lifetime)
+	}
+}
+
+func TestRecalculateTiersAtThresholds(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	fundTestUser(t, db, "alice", 10000)
+	fundTestUser(t, db, "bob", 9999)
+
+	tiers := []config.LoyaltyTier{
+		{Name: "silver", MinAccrual: 10000, Multiplier: 110},
+		{Name: "gold", MinAccrual: 20000, Multiplier: 125},
+	}
+	if _, err := db.RecalculateTiers(ctx, tiers); err != nil {
+		t.Fatal(err)
+	}
+	if p := userTier(t, db, "alice"); p.Tier != "silver" || p.Multiplier != 110 {
+		t.Errorf("alice at the silver threshold: tier = %q x%s", p.Tier, p.Multiplier)
+	}
+	if p := userTier(t, db, "bob"); p.Tier != "" || p.Multiplier != 100 {
+		t.Errorf("bob below silver: tier = %q x%s", p.Tier, p.Multiplier)
+	}
+
+	fundTestUser(t, db, "alice", 10000)
+	changes, err := db.RecalculateTiers(ctx, tiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.TierChange{User: "alice", From: "silver", To: "gold"}
+	if len(changes) != 1 || changes[0] != want {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+
+	tiers[1].MinAccrual = 30000
+	changes, err = db.RecalculateTiers(ctx, tiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = models.TierChange{User: "alice", From: "gold", To: "silver"}
+	if len(changes) != 1 || changes[0] != want {
+		t.Errorf("changes after raising gold = %+v, want %+v", changes, want)
+	}
+	if p := userTier(t, db, "alice"); p.Multiplier != 110 {
+		t.Errorf("downgraded multiplier = %s, want 1.1", p.Multiplier)
+	}
+}
@@ -0,0 +1,84 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+// Transfer checks both limits under the sender lock, zero disables a limit.
+func (p *PGDB) Transfer(ctx context.Context, from, to string, sum, maxAmount, dailyLimit models.Money) error {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT username FROM users WHERE username IN ($1, $2) ORDER BY username FOR UPDATE`,
+		from, to)
+	if err != nil {
+		return fmt.Errorf("failed to lock user balances: %w", err)
+	}
+	locked, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("failed to lock user balances: %w", err)
+	}
+	if len(locked) < 2 {
+		return models.ErrNotFound
+	}
+
+	available, err := availableBalance(ctx, tx, from)
+	if err != nil {
+		return fmt.Errorf("failed to get user balance: %w", err)
+	}
+	if available < sum {
+		return models.ErrInsufficientFunds
+	}
+
+	if maxAmount > 0 && sum > maxAmount {
+		return models.ErrTransferLimit
+	}
+
+	if dailyLimit > 0 {
+		var sent models.Money
+		query := `SELECT COALESCE(-SUM(amount), 0) FROM ledger_entries
+					WHERE account = $1 AND kind = 'transfer' AND amount < 0 AND created_at > $2`
+		err = tx.QueryRow(ctx, query, userAccount(from), time.Now().Add(-24*time.Hour)).Scan(&sent)
+		if err != nil {
+			return fmt.Errorf("failed to get sent transfers: %w", err)
+		}
+		if sent+sum > dailyLimit {
+			return models.ErrTransferLimit
+		}
+	}
+
+	lots, err := consumeLots(ctx, tx, from, sum)
+	if err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		if err := addLotAt(ctx, tx, to, "", lot.remaining, lot.creditedAt); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET accrual = accrual + CASE WHEN username = $1 THEN -$3::NUMERIC ELSE $3::NUMERIC END
+				WHERE username IN ($1, $2)`, from, to, sum)
+	if err != nil {
+		return fmt.Errorf("failed to update user balances: %w", err)
+	}
+
+	err = postLedger(ctx, tx, models.LedgerTransfer, "", userLeg(from, -sum), userLeg(to, sum))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+)
+
+const (
+	scanInterval     = time.Second
+	idleScanInterval = 30 * time.Second
+	claimLease       = time.Minute
+	leaseRenewal     = claimLease / 3
+)
+
+type JobSource interface {
+	ClaimAccrualJobs(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.AccrualJob, error)
+	NextAccrualJobAt(ctx context.Context) (time.Time, bool, error)
+}
+
+type Dispatcher struct {
+	db        JobSource
+	logger    *logging.Logger
+	jobs      chan models.AccrualJob
+	wakeCh    chan struct{}
+	owner     string
+	batch     int
+	listening atomic.Bool
+}
+
+func NewDispatcher(db JobSource, logger *logging.Logger, owner string, batch int) *Dispatcher {
+	if batch < 1 {
+		batch = 1
+	}
+
+	return &Dispatcher{
+		db:     db,
+		logger: logger,
+		jobs:   make(chan models.AccrualJob),
+		wakeCh: make(chan struct{}, 1),
+		owner:  owner,
+		batch:  batch,
+	}
+}
+
+func (d *Dispatcher) Owner() string {
+	return d.owner
+}
+
+func (d *Dispatcher) Jobs() <-chan models.AccrualJob {
+	return d.jobs
+}
+
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) SetListening(listening bool) {
+	d.listening.Store(listening)
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	defer close(d.jobs)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		d.dispatchJobs(ctx)
+		timer.Reset(d.nextScan(ctx))
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wakeCh:
+		case <-timer.C:
+		}
+	}
+}
+
+func (d *Dispatcher) nextScan(ctx context.Context) time.Duration {
+	if !d.listening.Load() {
+		return scanInterval
+	}
+
+	next, ok, err := d.db.NextAccrualJobAt(ctx)
+	if err != nil {
+		d.logger.Logger.Errorw("Failed to get next accrual job time", "err", err)
+		return scanInterval
+	}
+	if !ok {
+		return idleScanInterval
+	}
+
+	wait := time.Until(next)
+	if wait < 0 {
+		return 0
+	}
+	if wait > idleScanInterval {
+		return idleScanInterval
+	}
+	return wait
+}
+
+func (d *Dispatcher) dispatchJobs(ctx context.Context) {
+	for ctx.Err() == nil {
+		jobs, err := d.db.ClaimAccrualJobs(ctx, d.owner, d.batch, claimLease)
+		if err != nil {
+			d.logger.Logger.Errorw("Failed to claim accrual jobs", "err", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+
+		for _, job := range jobs {
+			select {
+			case <-ctx.Done():
+				return
+			case d.jobs <- job:
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/storage/pg"
+)
+
+const reconnectDelay = 5 * time.Second
+
+type Listener struct {
+	db         *pg.PGDB
+	dispatcher *Dispatcher
+	logger     *logging.Logger
+}
+
+func NewListener(db *pg.PGDB, dispatcher *Dispatcher, logger *logging.Logger) *Listener {
+	return &Listener{db: db, dispatcher: dispatcher, logger: logger}
+}
+
+func (l *Listener) Run(ctx context.Context) {
+	for {
+		err := l.db.ListenAccrualJobs(ctx, func() {
+			l.dispatcher.SetListening(true)
+		}, func(string) {
+			l.dispatcher.Wake()
+		})
+		l.dispatcher.SetListening(false)
+		if ctx.Err() != nil {
+			return
+		}
+
+		l.logger.Logger.Warnw("Accrual jobs listener dropped, falling back to periodic scan", "err", err)
+		l.dispatcher.Wake()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
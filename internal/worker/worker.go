@@ -2,111 +2,238 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"sync"
 	"time"
 
+	"github.com/sinfirst/Ref-System/internal/accrual"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
 	"github.com/sinfirst/Ref-System/internal/models"
 	"github.com/sinfirst/Ref-System/internal/storage/pg"
 )
 
+const (
+	retryDelay  = 10 * time.Second
+	maxAttempts = 12
+
+	recoveryLockKey int64 = 4201
+)
+
+type AccrualClient interface {
+	GetOrderAccrual(ctx context.Context, order string) (models.OrderResponse, error)
+}
+
+type Storage interface {
+	TryAdvisoryLock(ctx context.Context, key int64) (func(), bool, error)
+	RecoverAccrualJobs(ctx context.Context) (int64, error)
+	UpdateStatus(ctx context.Context, newStatus, order, user string) error
+	RenewAccrualJobLease(ctx context.Context, owner, order string, lease time.Duration) error
+	RescheduleAccrualJob(ctx context.Context, owner, order string, delay time.Duration, lastError string) error
+	DeferAccrualJob(ctx context.Context, owner, order string, delay time.Duration) error
+	DeadLetterAccrualJob(ctx context.Context, owner, order string, lastError string) error
+}
+
+type Processor interface {
+	ApplyLeased(ctx context.Context, owner, order, user string, response models.OrderResponse) (bool, error)
+}
+
 type Worker struct {
-	pollCh  chan models.TypeForChannel
-	db      *pg.PGDB
-	accrual string
-	wg      sync.WaitGroup
+	dispatcher *Dispatcher
+	db         Storage
+	accrual    AccrualClient
+	processor  Processor
+	logger     *logging.Logger
+	wg         sync.WaitGroup
 }
 
-func NewPollWorker(ctx context.Context, accrual string, db *pg.PGDB, pollCh chan models.TypeForChannel) *Worker {
+func NewPollWorker(ctx context.Context, client AccrualClient, db *pg.PGDB, processor Processor, logger *logging.Logger, dispatcher *Dispatcher, size int) *Worker {
+	if size < 1 {
+		size = 1
+	}
+
 	worker := &Worker{
-		db:      db,
-		pollCh:  pollCh,
-		accrual: accrual,
+		dispatcher: dispatcher,
+		db:         db,
+		accrual:    client,
+		processor:  processor,
+		logger:     logger,
 	}
 
-	worker.wg.Add(1)
-	go worker.PollOrderStatus(ctx)
+	worker.recoverJobs(ctx)
+
+	listener := NewListener(db, dispatcher, logger)
+
+	worker.wg.Add(size + 2)
+	go func() {
+		defer worker.wg.Done()
+		listener.Run(ctx)
+	}()
+	go func() {
+		defer worker.wg.Done()
+		dispatcher.Run(ctx)
+	}()
+	for i := 0; i < size; i++ {
+		go worker.PollOrderStatus(ctx)
+	}
 
 	return worker
 }
 
+func (w *Worker) recoverJobs(ctx context.Context) {
+	release, ok, err := w.db.TryAdvisoryLock(ctx, recoveryLockKey)
+	if err != nil {
+		w.logger.Logger.Errorw("Failed to take recovery lock", "err", err)
+		return
+	}
+	if !ok {
+		w.logger.Logger.Infow("Recovery is running on another replica")
+		return
+	}
+	defer release()
+
+	recovered, err := w.db.RecoverAccrualJobs(ctx)
+	if err != nil {
+		w.logger.Logger.Errorw("Failed to recover unfinished orders", "err", err)
+		return
+	}
+
+	w.logger.Logger.Infow("Recovered unfinished orders for polling", "count", recovered)
+}
+
 func (w *Worker) PollOrderStatus(ctx context.Context) {
 	defer w.wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case order, ok := <-w.pollCh:
-			if !ok {
-				return
-			}
-			err := w.Poll(ctx, order)
-			if err != nil {
-				return
-			}
+	for job := range w.dispatcher.Jobs() {
+		w.processJob(ctx, job)
+	}
+}
+
+func (w *Worker) processJob(ctx context.Context, job models.AccrualJob) {
+	leaseCtx, stop, err := w.keepLease(ctx, job)
+	if err != nil {
+		w.logLeaseError("Failed to take over claimed accrual job", job, err)
+		return
+	}
+
+	done, err := w.Poll(leaseCtx, job)
+	lost := leaseCtx.Err() != nil
+	stop()
+	if ctx.Err() != nil {
+		return
+	}
+	if lost {
+		w.logger.Logger.Warnw("Accrual job lease lost while polling", "order", job.OrderNum)
+		return
+	}
+
+	if done {
+		return
+	}
+
+	var rateErr *accrual.RateLimitError
+	if errors.As(err, &rateErr) {
+		w.deferJob(ctx, job, rateErr.RetryAfter)
+		return
+	}
+	if errors.Is(err, accrual.ErrCircuitOpen) {
+		w.deferJob(ctx, job, retryDelay)
+		return
+	}
+
+	lastError := "status is not final"
+	if err != nil {
+		lastError = err.Error()
+	}
+
+	if job.Attempts+1 >= maxAttempts {
+		w.logger.Logger.Warnw("Accrual job exhausted attempts", "order", job.OrderNum, "err", lastError)
+		if err := w.db.DeadLetterAccrualJob(ctx, w.dispatcher.Owner(), job.OrderNum, lastError); err != nil {
+			w.logLeaseError("Failed to dead-letter accrual job", job, err)
 		}
+		return
+	}
+
+	if err := w.db.RescheduleAccrualJob(ctx, w.dispatcher.Owner(), job.OrderNum, retryDelay, lastError); err != nil {
+		w.logLeaseError("Failed to reschedule accrual job", job, err)
+	}
+	w.dispatcher.Wake()
+}
 
+func (w *Worker) deferJob(ctx context.Context, job models.AccrualJob, delay time.Duration) {
+	if err := w.db.DeferAccrualJob(ctx, w.dispatcher.Owner(), job.OrderNum, delay); err != nil {
+		w.logLeaseError("Failed to defer accrual job", job, err)
 	}
+	w.dispatcher.Wake()
 }
-func (w *Worker) Poll(ctx context.Context, order models.TypeForChannel) error {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-	timeout := time.After(2 * time.Minute)
-	attempts := 0
-	maxAttempts := 12
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-ticker.C:
-			var response models.OrderResponse
-			attempts++
-
-			if attempts > maxAttempts {
-				return fmt.Errorf("превышено количество попыток")
+
+// keepLease renews the claim right away, since the job may have waited in the dispatcher channel,
+// and then keeps renewing it until stop is called. The returned context is cancelled once the lease is lost.
+func (w *Worker) keepLease(ctx context.Context, job models.AccrualJob) (context.Context, func(), error) {
+	owner := w.dispatcher.Owner()
+	if err := w.db.RenewAccrualJobLease(ctx, owner, job.OrderNum, claimLease); err != nil {
+		return nil, nil, err
+	}
+
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(leaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
 			}
-			url := fmt.Sprintf("%s/api/orders/%s", w.accrual, order.OrderNum)
-			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-			if err != nil {
-				fmt.Println("req create error:", err)
-				continue
+
+			err := w.db.RenewAccrualJobLease(leaseCtx, owner, job.OrderNum, claimLease)
+			if errors.Is(err, models.ErrLeaseLost) {
+				cancel()
+				return
 			}
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				fmt.Println("poll error:", err)
-				continue
+			if err != nil && leaseCtx.Err() == nil {
+				w.logger.Logger.Errorw("Failed to renew accrual job lease", "order", job.OrderNum, "err", err)
 			}
+		}
+	}()
 
-			body, err := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			if err != nil {
-				fmt.Println("read response error:", err)
-				continue
-			}
+	return leaseCtx, func() {
+		cancel()
+		<-done
+	}, nil
+}
 
-			err = json.Unmarshal(body, &response)
-			if err != nil {
-				return fmt.Errorf("ошибка парсинга: ")
-			}
+func (w *Worker) logLeaseError(msg string, job models.AccrualJob, err error) {
+	if errors.Is(err, models.ErrLeaseLost) {
+		w.logger.Logger.Warnw(msg, "order", job.OrderNum, "owner", w.dispatcher.Owner(), "err", err)
+		return
+	}
+	w.logger.Logger.Errorw(msg, "order", job.OrderNum, "err", err)
+}
 
-			if response.Status == "PROCESSED" {
-				err = w.db.UpdateOrderProgress(ctx, response.Status, response.Order, order.User, float64(response.Accrual), 0)
-				if err != nil {
-					return fmt.Errorf("error in update db: ")
-				}
-				return nil
-			} else {
-				fmt.Println(response.Status, " not equal PROCESSED!")
-			}
-		case <-timeout:
-			return fmt.Errorf("time is out")
+func (w *Worker) Poll(ctx context.Context, job models.AccrualJob) (bool, error) {
+	response, err := w.accrual.GetOrderAccrual(ctx, job.OrderNum)
+	if errors.Is(err, accrual.ErrOrderNotRegistered) {
+		if err := w.db.UpdateStatus(ctx, models.StatusNew, job.OrderNum, job.User); err != nil {
+			return false, fmt.Errorf("error in update db: %w", err)
 		}
+		return false, err
 	}
+	if err != nil {
+		return false, err
+	}
+
+	final, err := w.processor.ApplyLeased(ctx, w.dispatcher.Owner(), job.OrderNum, job.User, response)
+	if err != nil {
+		return false, err
+	}
+	if !final {
+		return false, fmt.Errorf("status %s is not final", response.Status)
+	}
+	return true, nil
 }
+
 func (w *Worker) StopWorker() {
 	w.wg.Wait()
 }
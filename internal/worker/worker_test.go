@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sinfirst/Ref-System/internal/accrual"
+	"github.com/sinfirst/Ref-System/internal/accrual/accrualtest"
+	"github.com/sinfirst/Ref-System/internal/middleware/logging"
+	"github.com/sinfirst/Ref-System/internal/models"
+	"github.com/sinfirst/Ref-System/internal/processor"
+)
+
+type memStore struct {
+	mu          sync.Mutex
+	statuses    map[string]string
+	credited    map[string]models.Money
+	completed   map[string]bool
+	rescheduled map[string]string
+	deferred    map[string]time.Duration
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		statuses:    make(map[string]string),
+		credited:    make(map[string]models.Money),
+		completed:   make(map[string]bool),
+		rescheduled: make(map[string]string),
+		deferred:    make(map[string]time.Duration),
+	}
+}
+
+func (s *memStore) TryAdvisoryLock(ctx context.Context, key int64) (func(), bool, error) {
+	return func() {}, true, nil
+}
+
+func (s *memStore) RecoverAccrualJobs(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (s *memStore) UpdateStatus(ctx context.Context, newStatus, order, user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statuses[order] = newStatus
+	return nil
+}
+
+func (s *memStore) UpdateOrderProgress(ctx context.Context, order string, accrual models.Money) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.statuses[order] == models.StatusProcessed {
+		return false, nil
+	}
+	s.statuses[order] = models.StatusProcessed
+	s.credited[order] = accrual
+	return true, nil
+}
+
+func (s *memStore) CompleteAccrualJob(ctx context.Context, owner, order string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.completed[order] = true
+	return nil
+}
+
+func (s *memStore) RenewAccrualJobLease(ctx context.Context, owner, order string, lease time.Duration) error {
+	return nil
+}
+
+func (s *memStore) RescheduleAccrualJob(ctx context.Context, owner, order string, delay time.Duration, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rescheduled[order] = lastError
+	return nil
+}
+
+func (s *memStore) DeferAccrualJob(ctx context.Context, owner, order string, delay time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deferred[order] = delay
+	return nil
+}
+
+func (s *memStore) DeadLetterAccrualJob(ctx context.Context, owner, order string, lastError string) error {
+	return nil
+}
+
+func (s *memStore) ClaimAccrualJobs(ctx context.Context, owner string, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	return nil, nil
+}
+
+func (s *memStore) NextAccrualJobAt(ctx context.Context) (time.Time, bool, error) {
+	return time.Time{}, false, nil
+}
+
+func newTestWorker(store *memStore, client AccrualClient) *Worker {
+	logger := logging.NewLogger()
+
+	return &Worker{
+		dispatcher: NewDispatcher(store, logger, "test", 1),
+		db:         store,
+		accrual:    client,
+		processor:  processor.NewProcessor(store, logger),
+		logger:     logger,
+	}
+}
+
+func TestProcessJobRegisteredIsRescheduled(t *testing.T) {
+	store := newMemStore()
+	fake := accrualtest.NewFake()
+	fake.Set("1", accrualtest.FakeResult{Response: models.OrderResponse{Order: "1", Status: models.StatusRegistered}})
+
+	newTestWorker(store, fake).processJob(context.Background(), models.AccrualJob{OrderNum: "1", User: "alice"})
+
+	if store.statuses["1"] != models.StatusNew {
+		t.Errorf("status = %q, want %s", store.statuses["1"], models.StatusNew)
+	}
+	if _, ok := store.rescheduled["1"]; !ok || store.completed["1"] {
+		t.Error("registered order must be rescheduled, not completed")
+	}
+}
+
+func TestProcessJobRateLimitedIsDeferred(t *testing.T) {
+	store := newMemStore()
+	fake := accrualtest.NewFake()
+	fake.Set("1", accrualtest.FakeResult{Err: &accrual.RateLimitError{RetryAfter: 7 * time.Second}})
+
+	newTestWorker(store, fake).processJob(context.Background(), models.AccrualJob{OrderNum: "1", User: "alice"})
+
+	if delay := store.deferred["1"]; delay != 7*time.Second {
+		t.Errorf("deferred by %s, want Retry-After 7s", delay)
+	}
+	if _, ok := store.rescheduled["1"]; ok {
+		t.Error("rate limited job must not spend an attempt")
+	}
+}
+
+func TestProcessJobNotRegisteredIsRescheduled(t *testing.T) {
+	store := newMemStore()
+	fake := accrualtest.NewFake()
+
+	newTestWorker(store, fake).processJob(context.Background(), models.AccrualJob{OrderNum: "1", User: "alice"})
+
+	if store.statuses["1"] != models.StatusNew {
+		t.Errorf("status = %q, want %s", store.statuses["1"], models.StatusNew)
+	}
+	if lastError := store.rescheduled["1"]; lastError != accrual.ErrOrderNotRegistered.Error() {
+		t.Errorf("last error = %q, want %q", lastError, accrual.ErrOrderNotRegistered)
+	}
+}
+
+func TestProcessJobFinalStatusCompletes(t *testing.T) {
+	store := newMemStore()
+	fake := accrualtest.NewFake()
+	fake.Set("1", accrualtest.FakeResult{Response: models.OrderResponse{Order: "1", Status: models.StatusProcessed, Accrual: 500}})
+
+	newTestWorker(store, fake).processJob(context.Background(), models.AccrualJob{OrderNum: "1", User: "alice"})
+
+	if store.credited["1"] != 500 || !store.completed["1"] {
+		t.Errorf("credited = %s, completed = %v, want 5 and completed", store.credited["1"], store.completed["1"])
+	}
+	if _, ok := store.rescheduled["1"]; ok {
+		t.Error("final order must not be rescheduled")
+	}
+	if calls := fake.Calls("1"); calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestProcessJobNegativeAccrualIsNotCredited(t *testing.T) {
+	store := newMemStore()
+	fake := accrualtest.NewFake()
+	fake.Set("1", accrualtest.FakeResult{Response: models.OrderResponse{Order: "1", Status: models.StatusProcessed, Accrual: -500}})
+
+	newTestWorker(store, fake).processJob(context.Background(), models.AccrualJob{OrderNum: "1", User: "alice"})
+
+	if _, ok := store.credited["1"]; ok || store.completed["1"] {
+		t.Error("negative accrual must not be credited")
+	}
+	if _, ok := store.rescheduled["1"]; !ok {
+		t.Error("order with negative accrual must be retried")
+	}
+}